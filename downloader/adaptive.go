@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	adaptiveWindow   = 500 * time.Millisecond // how often allowed rate is reconsidered
+	queueDelayTarget = 100 * time.Millisecond // extra read wait over base treated as congestion
+)
+
+// simplified LEDBAT like controller, it lowers allowed rate when reads start
+// to wait longer than on idle link or when allowed rate can't be reached
+// (both signal competing traffic) and slowly climbs back to max otherwise
+type adaptiveLimiter struct {
+	max  float64 // upper cap in byte/s
+	min  float64 // rate never drops below this so download keeps moving
+	rate float64 // currently allowed rate in byte/s
+
+	baseWait time.Duration // lowest average read wait seen, approximates uncongested link
+	haveBase bool
+
+	windowStart time.Time
+	windowBytes int64
+	windowWait  time.Duration
+	windowReads int
+}
+
+// limiter starting its first window at now
+func newAdaptiveLimiter(maxSpeedBytes int64, now time.Time) *adaptiveLimiter {
+	limit := float64(maxSpeedBytes)
+	return &adaptiveLimiter{
+		max:         limit,
+		min:         limit / 20,
+		rate:        limit / 4,
+		windowStart: now,
+	}
+}
+
+// record one read that waited wait and ended at now and return how long to
+// sleep to keep allowed rate
+func (l *adaptiveLimiter) observe(n int, wait time.Duration, now time.Time) time.Duration {
+	l.windowBytes += int64(n)
+	l.windowWait += wait
+	l.windowReads++
+
+	if elapsed := now.Sub(l.windowStart); elapsed >= adaptiveWindow {
+		l.adjust(elapsed, now)
+	}
+
+	pause := time.Duration(float64(n)/l.rate*float64(time.Second)) - wait
+	return max(pause, 0)
+}
+
+// reconsider allowed rate at the end of window, next one starts at now
+func (l *adaptiveLimiter) adjust(elapsed time.Duration, now time.Time) {
+	avgWait := l.windowWait / time.Duration(l.windowReads)
+	measured := float64(l.windowBytes) / elapsed.Seconds()
+
+	if !l.haveBase || avgWait < l.baseWait {
+		l.baseWait = avgWait
+		l.haveBase = true
+	}
+
+	congested := avgWait > l.baseWait+queueDelayTarget || measured < 0.8*l.rate
+	if congested {
+		l.rate = max(l.rate*0.7, l.min)
+	} else {
+		l.rate = min(l.rate+l.max/10, l.max)
+	}
+
+	l.windowStart = now
+	l.windowBytes = 0
+	l.windowWait = 0
+	l.windowReads = 0
+}
+
+// same as DownloadChunksWithLimit but maxSpeedBytes is only upper cap, the
+// rate backs off when competing traffic is detected on the link so the
+// download can run in background without hurting interactive traffic,
+// Download uses it when AdaptiveLimit is set
+func (d *Downloader) DownloadChunksAdaptive(ctx context.Context, body io.Reader, maxSpeedBytes int64) error {
+	buf, release := d.getBuffer()
+	defer release()
+	limiter := newAdaptiveLimiter(maxSpeedBytes, d.now())
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		readStart := d.now()
+		n, readErr := body.Read(buf)
+		readEnd := d.now()
+		wait := readEnd.Sub(readStart)
+
+		if n > 0 {
+			if writeErr := d.writeChunk(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if err := d.sleep(ctx, limiter.observe(n, wait, readEnd)); err != nil {
+				return err
+			}
+			if err := d.Limiter.WaitN(ctx, n); err != nil {
+				return err
+			}
+		}
+		if readErr != nil {
+			return readResult(ctx, readErr)
+		}
+	}
+}
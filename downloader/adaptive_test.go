@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// transport whose response bodies fill whole read buffer and, once after
+// bytes passed, make every read take delay of virtual time like link
+// queueing behind competing traffic
+type congestedTransport struct {
+	clock *fakeClock
+	after int64
+	delay time.Duration
+}
+
+func (t *congestedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &congestedBody{ReadCloser: resp.Body, t: t}
+	return resp, nil
+}
+
+type congestedBody struct {
+	io.ReadCloser
+	t    *congestedTransport
+	read int64
+}
+
+func (b *congestedBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt64(&b.read) >= b.t.after {
+		b.t.clock.Sleep(b.t.delay)
+	}
+	n, err := io.ReadFull(b.ReadCloser, p)
+	atomic.AddInt64(&b.read, int64(n))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// average rate in byte/s between from and to
+func rateBetween(tl *timeline, from, to time.Duration) float64 {
+	var before, until int64
+	for i, at := range tl.at {
+		if at <= from {
+			before = tl.total[i]
+		}
+		if at <= to {
+			until = tl.total[i]
+		}
+	}
+	return float64(until-before) / (to - from).Seconds()
+}
+
+func TestAdaptiveLimitFollowsThroughput(t *testing.T) {
+	const limit = 256 << 10
+	const idle = 3 << 19 // 1.5 MiB
+	data := testData(idle + 1<<20)
+
+	fc := newFakeClock()
+	tl := newTimeline(fc)
+	d := newTestDownloader(t, newFileServer(t, data).URL)
+	d.clock = fc.clock()
+	d.SpeedLimit = limit
+	d.AdaptiveLimit = true
+	d.Transport = &congestedTransport{clock: fc, after: idle, delay: 150 * time.Millisecond}
+	if err := d.DownloadTo(tl); err != nil {
+		t.Fatal(err)
+	}
+
+	// idle link climbs from quarter of cap to the cap itself
+	var congestedAt time.Duration
+	for i, total := range tl.total {
+		if total >= idle {
+			congestedAt = tl.at[i]
+			break
+		}
+	}
+	if rate := rateBetween(tl, 0, time.Second); rate > 0.5*limit {
+		t.Errorf("first second ran at %.0f byte/s, want slow start", rate)
+	}
+	if rate := rateBetween(tl, congestedAt-time.Second, congestedAt); rate < 0.9*limit || rate > 1.05*limit {
+		t.Errorf("idle link ran at %.0f byte/s, want %d", rate, limit)
+	}
+
+	// reads delayed over idle ones back off to floor of the cap, link itself
+	// would carry far more
+	end := tl.at[len(tl.at)-1]
+	if rate := rateBetween(tl, end-10*time.Second, end); rate > limit/10 {
+		t.Errorf("congested link ran at %.0f byte/s, want under %d", rate, limit/10)
+	}
+	if got := tl.total[len(tl.total)-1]; got != int64(len(data)) {
+		t.Fatalf("received %d bytes, want %d", got, len(data))
+	}
+}
@@ -0,0 +1,44 @@
+package downloader
+
+import "sync"
+
+// BufferPool shares read buffers between downloaders, e.g. all downloads of
+// one batch, so every download doesn't allocate its own buffer
+type BufferPool struct {
+	size int64
+	pool sync.Pool
+}
+
+// create pool of buffers with given size, it should match BufferSize of
+// downloaders using the pool, others allocate their own buffers
+func NewBufferPool(size int64) *BufferPool {
+	p := &BufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// default size of read buffer
+const defaultBufferSize = 32768
+
+// BufferSize or default when it is not positive
+func (d *Downloader) bufferSize() int64 {
+	if d.BufferSize <= 0 {
+		return defaultBufferSize
+	}
+	return d.BufferSize
+}
+
+// get buffer for read loop, release must be called once loop no longer
+// references the buffer
+func (d *Downloader) getBuffer() (buf []byte, release func()) {
+	size := d.bufferSize()
+	p := d.Buffers
+	if p == nil || p.size != size {
+		return make([]byte, size), func() {}
+	}
+	ptr := p.pool.Get().(*[]byte)
+	return *ptr, func() { p.pool.Put(ptr) }
+}
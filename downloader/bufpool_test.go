@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// read loop of batch of 100 downloads, each file is small so buffer
+// allocation is significant part of its cost
+func BenchmarkBatchBuffers(b *testing.B) {
+	data := testData(64 << 10)
+	for _, bench := range []struct {
+		name string
+		pool *BufferPool
+	}{
+		{"NoPool", nil},
+		{"Pool", NewBufferPool(defaultBufferSize)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)) * 100)
+			for range b.N {
+				for range 100 {
+					d := &Downloader{Buffers: bench.pool, sink: io.Discard}
+					if err := d.DownloadChunks(context.Background(), bytes.NewReader(data)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBufferPoolReusesBuffers(t *testing.T) {
+	pool := NewBufferPool(defaultBufferSize)
+	d := &Downloader{Buffers: pool}
+	buf, release := d.getBuffer()
+	if len(buf) != defaultBufferSize {
+		t.Fatalf("got buffer of %d bytes, want %d", len(buf), defaultBufferSize)
+	}
+	release()
+
+	// pool of other size is not used
+	d.BufferSize = 1024
+	if buf, _ := d.getBuffer(); len(buf) != 1024 {
+		t.Errorf("got buffer of %d bytes, want 1024", len(buf))
+	}
+}
+
+// throughput of whole download from local server with different read
+// buffer sizes, the default should be close to the fastest one
+func BenchmarkBufferSize(b *testing.B) {
+	data := testData(8 << 20)
+	srv := newFileServer(b, data)
+	for _, size := range []int64{4 << 10, 32 << 10, 256 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				d := NewDownloader(srv.URL, "", false)
+				d.Quiet = true
+				d.BufferSize = size
+				if err := d.DownloadTo(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestBufferSizeFallsBackToDefault(t *testing.T) {
+	for _, size := range []int64{0, -1, -32768} {
+		d := &Downloader{BufferSize: size}
+		if buf, _ := d.getBuffer(); len(buf) != defaultBufferSize {
+			t.Errorf("BufferSize %d: got buffer of %d bytes, want %d", size, len(buf), defaultBufferSize)
+		}
+	}
+}
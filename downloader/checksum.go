@@ -0,0 +1,293 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SignedChecksums describes checksum file (like SHA256SUMS) protected by
+// detached GPG signature, signature is checked before any checksum is trusted
+type SignedChecksums struct {
+	ChecksumFile  string // path to checksum file in "<hex>  <filename>" format
+	SignatureFile string // path to detached signature of ChecksumFile
+	PublicKeyFile string // path to public key (armored or binary) trusted to sign ChecksumFile
+}
+
+// verify signature of checksum file and then downloaded data against checksum
+// listed for the downloaded file name, size limits hashed bytes when > 0
+func (s *SignedChecksums) verify(dataPath, name string, size int64) error {
+	if err := verifySignature(s.ChecksumFile, s.SignatureFile, s.PublicKeyFile); err != nil {
+		return err
+	}
+
+	f, err := os.Open(s.ChecksumFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sums, err := parseChecksumManifest(f)
+	if err != nil {
+		return err
+	}
+	expected, ok := sums[name]
+	if !ok {
+		return fmt.Errorf("no checksum for %s in %s", name, s.ChecksumFile)
+	}
+
+	h, err := hashForDigest(expected)
+	if err != nil {
+		return err
+	}
+	return verifyFileDigest(dataPath, h, expected, size)
+}
+
+// check detached signature with external gpg, key is imported into temporary
+// home directory so user's keyring is neither used nor modified
+func verifySignature(filePath, sigPath, keyPath string) error {
+	home, err := os.MkdirTemp("", "medow-gpg-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(home)
+
+	out, err := exec.Command("gpg", "--homedir", home, "--batch", "--quiet", "--import", keyPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("importing public key %s: %v: %s", keyPath, err, strings.TrimSpace(string(out)))
+	}
+
+	out, err = exec.Command("gpg", "--homedir", home, "--batch", "--quiet", "--verify", sigPath, filePath).CombinedOutput()
+	if err != nil {
+		if _, isExit := err.(*exec.ExitError); isExit {
+			return &SignatureError{File: filePath, Output: strings.TrimSpace(string(out))}
+		}
+		return err
+	}
+	return nil
+}
+
+// parse checksum file made by sha256sum and similar tools, lines have form
+// "<hex>  <filename>" or "<hex> *<filename>" for binary mode
+func parseChecksumManifest(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, name, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		sums[name] = strings.ToLower(digest)
+	}
+	return sums, scanner.Err()
+}
+
+// largest checksum manifest read from ChecksumURL
+const maxManifestSize = 16 << 20
+
+// fill ExpectedChecksum from manifest at ChecksumURL, it is looked up by
+// local file name and then by name in Url, manifest is fetched only once
+func (d *Downloader) resolveChecksumURL(ctx context.Context) error {
+	if d.ChecksumURL == "" || d.ExpectedChecksum != "" {
+		return nil
+	}
+
+	// relative manifest URL is taken from directory of Url
+	manifestURL, err := url.Parse(d.ChecksumURL)
+	if err != nil {
+		return fmt.Errorf("invalid checksum URL: %w", err)
+	}
+	if base, err := url.Parse(d.Url); err == nil {
+		manifestURL = base.ResolveReference(manifestURL)
+	}
+
+	httpClient, err := d.httpClient()
+	if err != nil {
+		return err
+	}
+	req, err := d.newRequestURL(ctx, "GET", manifestURL.String())
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(httpClient, req)
+	if err != nil {
+		return fmt.Errorf("fetching checksum manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching checksum manifest %s: %w", manifestURL, d.badStatus(resp))
+	}
+	sums, err := parseChecksumManifest(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return fmt.Errorf("reading checksum manifest: %w", err)
+	}
+
+	var names []string
+	if d.FilePath != "" {
+		names = append(names, filepath.Base(d.FilePath))
+	}
+	if u, err := url.Parse(d.Url); err == nil && path.Base(u.Path) != "/" {
+		names = append(names, path.Base(u.Path))
+	}
+	for _, name := range names {
+		digest, ok := sums[name]
+		if !ok {
+			continue
+		}
+		algo, err := digestAlgorithm(digest)
+		if err != nil {
+			return err
+		}
+		d.ExpectedChecksum = algo + ":" + digest
+		d.debugf("checksum of %s from %s: %s", name, manifestURL, d.ExpectedChecksum)
+		return nil
+	}
+	return fmt.Errorf("no checksum for %s in %s", strings.Join(names, " or "), manifestURL)
+}
+
+// pick hash algorithm from length of hex digest
+func hashForDigest(digest string) (hash.Hash, error) {
+	algo, err := digestAlgorithm(digest)
+	if err != nil {
+		return nil, err
+	}
+	return newHash(algo)
+}
+
+// name of hash algorithm producing hex digest of this length
+func digestAlgorithm(digest string) (string, error) {
+	algos := map[int]string{32: "md5", 40: "sha1", 64: "sha256", 128: "sha512"}
+	algo, ok := algos[len(digest)]
+	if !ok {
+		return "", fmt.Errorf("unknown checksum format: %s", digest)
+	}
+	return algo, nil
+}
+
+// hash for algorithm name used in ExpectedChecksum
+func newHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algo)
+}
+
+// split checksum in "<algorithm>:<hex>" form, e.g. "sha256:abcd..."
+func parseChecksum(checksum string) (hash.Hash, string, error) {
+	algo, digest, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("checksum %q is not in <algorithm>:<hex> form", checksum)
+	}
+	h, err := newHash(algo)
+	if err != nil {
+		return nil, "", err
+	}
+	return h, strings.ToLower(digest), nil
+}
+
+// verify file at FilePath against ExpectedChecksum
+func (d *Downloader) VerifyChecksum() error {
+	return d.verifyChecksum(d.FilePath)
+}
+
+// hex digest of downloaded file computed during verification, empty when
+// ExpectedChecksum is not set or file was not verified yet
+func (d *Downloader) Checksum() string {
+	return d.checksum
+}
+
+// digest computed while downloading is used when the whole file passed
+// through hasher, resumed file is hashed again from disk because state of
+// hash for already present bytes is unknown
+func (d *Downloader) verifyChecksum(path string) error {
+	h, expected, err := parseChecksum(d.ExpectedChecksum)
+	if err != nil {
+		return err
+	}
+
+	if d.hasher != nil {
+		d.checksum = hex.EncodeToString(d.hasher.Sum(nil))
+	} else {
+		d.checksum, err = fileDigest(path, h, d.verifySize())
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.checksum != expected {
+		return &ChecksumMismatchError{Expected: expected, Actual: d.checksum}
+	}
+	return nil
+}
+
+// start hashing downloaded data when download starts from zero, retried
+// stream continues right after bytes already hashed so its hasher is kept
+func (d *Downloader) startHasher() {
+	if d.streaming() && d.hasher != nil && d.Downloaded > 0 {
+		return
+	}
+	d.hasher = nil
+	if d.ExpectedChecksum == "" || d.Downloaded > 0 {
+		return
+	}
+	if h, _, err := parseChecksum(d.ExpectedChecksum); err == nil {
+		d.hasher = h
+	}
+}
+
+// compute digest of file (or its first size bytes when size > 0) and compare
+// it with expected hex digest
+func verifyFileDigest(path string, h hash.Hash, expected string, size int64) error {
+	actual, err := fileDigest(path, h, size)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &ChecksumMismatchError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// hex digest of file, or of its first size bytes when size > 0
+func fileDigest(path string, h hash.Hash, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if size > 0 {
+		r = io.LimitReader(f, size)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,258 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// client used for requests, Client when set, otherwise it is created on first
+// use and then kept so repeated downloads reuse keep-alive connections,
+// options changed later don't affect already created client
+func (d *Downloader) httpClient() (*http.Client, error) {
+	if d.Client != nil {
+		return d.Client, nil
+	}
+	if d.client != nil {
+		return d.client, nil
+	}
+	client, err := d.newClient()
+	if err != nil {
+		return nil, err
+	}
+	d.client = client
+	return client, nil
+}
+
+// close keep-alive connections left idle after downloads finished, long
+// running programs should call this after batch of downloads so sockets
+// don't pile up until IdleConnTimeout closes them, Client set by caller is
+// left to its owner
+func (d *Downloader) CloseIdleConnections() {
+	if d.client != nil {
+		d.client.CloseIdleConnections()
+	}
+}
+
+// create HTTP client configured by downloader options
+func (d *Downloader) newClient() (*http.Client, error) {
+	if d.Transport != nil {
+		return &http.Client{Transport: d.Transport, CheckRedirect: d.checkRedirect, Jar: d.Jar}, nil
+	}
+
+	def, err := d.newTransport(d.HTTPVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.HostHTTPVersion) == 0 {
+		return &http.Client{Transport: def, CheckRedirect: d.checkRedirect, Jar: d.Jar}, nil
+	}
+
+	router := &hostTransport{def: def, hosts: make(map[string]http.RoundTripper)}
+	byVersion := map[string]http.RoundTripper{d.HTTPVersion: def}
+	for host, version := range d.HostHTTPVersion {
+		t, ok := byVersion[version]
+		if !ok {
+			t, err = d.newTransport(version)
+			if err != nil {
+				return nil, fmt.Errorf("host %s: %w", host, err)
+			}
+			byVersion[version] = t
+		}
+		router.hosts[host] = t
+	}
+
+	return &http.Client{Transport: router, CheckRedirect: d.checkRedirect, Jar: d.Jar}, nil
+}
+
+// enforce MaxRedirects and carry Range over every hop, without Range a
+// resumed request would silently turn into full download written at resume
+// offset, Authorization is carried only while host stays the same and is
+// removed otherwise (net/http keeps it for subdomains)
+func (d *Downloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	if limit := d.maxRedirects(); len(via) > limit {
+		return &RedirectLimitError{Limit: limit, Location: req.URL.String()}
+	}
+	prev := via[len(via)-1]
+	switch req.URL.Scheme {
+	case "https":
+	case "http":
+		if prev.URL.Scheme == "https" && !d.AllowInsecureRedirect {
+			return &InsecureRedirectError{From: prev.URL.String(), Location: req.URL.String()}
+		}
+	default:
+		return &InsecureRedirectError{From: prev.URL.String(), Location: req.URL.String()}
+	}
+	if d.LogRedirects {
+		d.printf("Redirected to: %s\n", req.URL)
+	}
+	atomic.AddInt64(&d.redirects, 1)
+	d.debugf("redirect to %s", req.URL)
+	d.logger().Info("redirect", "from", prev.URL.String(), "to", req.URL.String())
+
+	first := via[0]
+	if r := first.Header.Get("Range"); r != "" {
+		req.Header.Set("Range", r)
+	}
+	if ifRange := first.Header.Get("If-Range"); ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	// .netrc credentials belong to host of redirect target
+	if auth := first.Header.Get("Authorization"); auth != "" && req.URL.Host == first.URL.Host {
+		req.Header.Set("Authorization", auth)
+	} else if login, password, ok := d.netrcAuth(req.URL.Hostname()); ok {
+		req.SetBasicAuth(login, password)
+	} else {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+const defaultMaxRedirects = 10
+
+// number of redirects followed, 0 when following is disabled
+func (d *Downloader) maxRedirects() int {
+	switch {
+	case d.MaxRedirects < 0:
+		return 0
+	case d.MaxRedirects == 0:
+		return defaultMaxRedirects
+	}
+	return d.MaxRedirects
+}
+
+// create transport speaking only given HTTP version, "1.1" or "2", empty
+// version leaves protocol to negotiation
+func (d *Downloader) newTransport(version string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableCompression = true // compression is requested only by RequestCompression
+	t.TLSClientConfig = &tls.Config{MinVersion: d.MinTLSVersion}
+	if d.CACertFile != "" {
+		pool, err := loadCACerts(d.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if d.InsecureSkipVerify {
+		d.debugf("WARNING: TLS certificate verification is disabled, connection is not secure")
+		d.logger().Warn("TLS certificate verification disabled", "url", d.Url)
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+	if d.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = d.IdleConnTimeout
+	}
+	if d.Timeout > 0 {
+		t.ResponseHeaderTimeout = d.Timeout
+	}
+	if d.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: d.ConnectTimeout, KeepAlive: 30 * time.Second}
+		t.DialContext = dialer.DialContext
+		t.TLSHandshakeTimeout = d.ConnectTimeout
+	}
+	if d.Proxy != "" {
+		proxyURL, err := parseProxy(d.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	switch version {
+	case "":
+		return t, nil
+	case "1.1":
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(true)
+	case "2":
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unsupported HTTP version %q, use \"1.1\" or \"2\"", version)
+	}
+	return t, nil
+}
+
+// system certificate pool extended with certificates from PEM file
+func loadCACerts(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificates: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid PEM certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// parse and validate proxy URL so typo is reported before anything is sent
+func parseProxy(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", rawURL, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be http, https or socks5", rawURL)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", rawURL)
+	}
+	return proxyURL, nil
+}
+
+// picks transport by request host so protocol can be pinned per host
+type hostTransport struct {
+	def   http.RoundTripper
+	hosts map[string]http.RoundTripper
+}
+
+func (h *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t, ok := h.hosts[req.URL.Hostname()]; ok {
+		return t.RoundTrip(req)
+	}
+	return h.def.RoundTrip(req)
+}
+
+// http.Client.CloseIdleConnections reaches only its own transport, so idle
+// connections of per host transports are closed here
+func (h *hostTransport) CloseIdleConnections() {
+	type closeIdler interface{ CloseIdleConnections() }
+	if t, ok := h.def.(closeIdler); ok {
+		t.CloseIdleConnections()
+	}
+	for _, t := range h.hosts {
+		if t, ok := t.(closeIdler); ok {
+			t.CloseIdleConnections()
+		}
+	}
+}
+
+// make handshake failure caused by MinTLSVersion recognizable, server
+// offering only older versions otherwise ends with generic handshake error
+func (d *Downloader) tlsVersionError(err error) error {
+	var alert tls.AlertError
+	const alertProtocolVersion = 70
+
+	msg := err.Error()
+	if (errors.As(err, &alert) && alert == alertProtocolVersion) ||
+		strings.Contains(msg, "protocol version not supported") ||
+		strings.Contains(msg, "unsupported protocol version") {
+		return fmt.Errorf("server doesn't support %s or newer: %w", tls.VersionName(d.MinTLSVersion), err)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server redirecting /N to /N-1 until /0 which serves data
+func newRedirectChainServer(t *testing.T, data []byte) *httptest.Server {
+	t.Helper()
+	files := newFileServer(t, data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Path[1:])
+		if err != nil || n <= 0 {
+			files.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		http.Redirect(w, r, "/"+strconv.Itoa(n-1), http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMaxRedirects(t *testing.T) {
+	data := testData(8 << 10)
+	srv := newRedirectChainServer(t, data)
+
+	tests := []struct {
+		name      string
+		max       int
+		redirects int
+		limit     int // Limit of expected RedirectLimitError, -1 when download succeeds
+	}{
+		{"default allows 10", 0, 10, -1},
+		{"default stops 11th", 0, 11, 10},
+		{"custom limit", 2, 2, -1},
+		{"custom limit exceeded", 2, 3, 2},
+		{"negative disables", -1, 1, 0},
+		{"negative without redirect", -1, 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDownloader(t, srv.URL+"/"+strconv.Itoa(tt.redirects))
+			d.MaxRedirects = tt.max
+			d.MaxRetries = 0
+			err := d.Download()
+			if tt.limit < 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkFile(t, d.FilePath, data)
+				return
+			}
+			var limitErr *RedirectLimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("got %v, want RedirectLimitError", err)
+			}
+			if limitErr.Limit != tt.limit {
+				t.Fatalf("error reports limit %d, want %d", limitErr.Limit, tt.limit)
+			}
+		})
+	}
+}
+
+func TestResumeKeepsRangeOnCrossHostRedirect(t *testing.T) {
+	data := testData(64 << 10)
+	files := newFileServer(t, data)
+
+	var gotRange, gotAuth string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		gotAuth = r.Header.Get("Authorization")
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer target.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/file.bin", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	d := newTestDownloader(t, origin.URL+"/file.bin")
+	d.SetHeader("Authorization", "Bearer secret")
+	writePartial(t, d, data, 20000)
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if gotRange != "bytes=20000-" {
+		t.Fatalf("redirect target got Range %q, want bytes=20000-", gotRange)
+	}
+	if gotAuth != "" {
+		t.Fatalf("Authorization %q was sent to other host", gotAuth)
+	}
+}
+
+func TestCloseIdleConnectionsOfHostTransport(t *testing.T) {
+	data := testData(1000)
+	files := newFileServer(t, data)
+	var closed atomic.Int32
+	srv := httptest.NewUnstartedServer(files.Config.Handler)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// request goes through transport pinned for its host, not default one
+	d := newTestDownloader(t, srv.URL)
+	d.HostHTTPVersion = map[string]string{"127.0.0.1": "1.1"}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	d.CloseIdleConnections()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for closed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if closed.Load() == 0 {
+		t.Error("idle connection of per host transport was left open")
+	}
+}
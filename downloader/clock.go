@@ -0,0 +1,43 @@
+package downloader
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// time source, waiting and randomness of retry backoff and rate limiting,
+// all fields are nil in production, tests set them to advance time by hand
+// and to get exact backoff sequences without real sleeping
+type clock struct {
+	nowFunc   func() time.Time    // nil uses time.Now
+	sleepFunc func(time.Duration) // nil waits on timer
+	rng       *rand.Rand          // jitter of backoff, nil uses global source
+}
+
+func (c clock) now() time.Time {
+	if c.nowFunc != nil {
+		return c.nowFunc()
+	}
+	return time.Now()
+}
+
+// wait for duration or until ctx is cancelled
+func (c clock) sleep(ctx context.Context, duration time.Duration) error {
+	if c.sleepFunc == nil {
+		return sleepContext(ctx, duration)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.sleepFunc(duration)
+	return ctx.Err()
+}
+
+// random duration in [0, n)
+func (c clock) randN(n time.Duration) time.Duration {
+	if c.rng != nil {
+		return time.Duration(c.rng.Int64N(int64(n)))
+	}
+	return rand.N(n)
+}
@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server gzipping data for requests accepting it, Range requests get plain
+// data, cut > 0 ends first compressed response after that many bytes,
+// headers of GET requests are recorded
+func newGzipServer(t *testing.T, data []byte, cut int) (*httptest.Server, func() []http.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	compressed := buf.Bytes()
+
+	files := newFileServer(t, data)
+	var mu sync.Mutex
+	var requests []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			files.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, r.Header.Clone())
+		first := len(requests) == 1
+		mu.Unlock()
+		if r.Header.Get("Range") != "" || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			files.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		if first && cut > 0 {
+			conn, bw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(bw, "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\nAccept-Ranges: bytes\r\n\r\n", len(compressed))
+			bw.Write(compressed[:cut])
+			bw.Flush()
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprint(len(compressed)))
+		for off := 0; off < len(compressed); off += 4096 {
+			w.Write(compressed[off:min(off+4096, len(compressed))])
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestRequestCompression(t *testing.T) {
+	data := testData(256 << 10)
+	srv, requests := newGzipServer(t, data, 0)
+
+	d := newTestDownloader(t, srv.URL)
+	d.RequestCompression = true
+	d.ProgressInterval = time.Millisecond
+	var mu sync.Mutex
+	var totals []int64
+	progressFile := false
+	d.OnProgress = func(downloaded, total int64, bps float64, eta int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		totals = append(totals, total)
+		if _, err := os.Stat(d.ProgressPath); err == nil {
+			progressFile = true
+		}
+	}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if got := requests()[0].Get("Accept-Encoding"); got != "gzip, deflate" {
+		t.Errorf("Accept-Encoding %q, want gzip, deflate", got)
+	}
+
+	// compressed size isn't size of file, progress is indeterminate
+	mu.Lock()
+	defer mu.Unlock()
+	if len(totals) == 0 {
+		t.Fatal("no progress reported")
+	}
+	for _, total := range totals {
+		if total != 0 {
+			t.Fatalf("progress total %d, want unknown", total)
+		}
+	}
+	if progressFile {
+		t.Error("progress file was kept for compressed download")
+	}
+}
+
+func TestRequestCompressionResume(t *testing.T) {
+	data := testData(256 << 10)
+
+	// resumed request asks for plain data, Range applies to them
+	srv, requests := newGzipServer(t, data, 0)
+	d := newTestDownloader(t, srv.URL)
+	d.RequestCompression = true
+	writePartial(t, d, data, 20000)
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	req := requests()[0]
+	if req.Get("Range") != "bytes=20000-" || req.Get("Accept-Encoding") != "" {
+		t.Errorf("resumed request has Range %q and Accept-Encoding %q, want Range only", req.Get("Range"), req.Get("Accept-Encoding"))
+	}
+
+	// interrupted compressed download leaves no progress behind, next run
+	// starts from zero and asks for compression again
+	srv, requests = newGzipServer(t, data, 5000)
+	d = newTestDownloader(t, srv.URL)
+	d.RequestCompression = true
+	d.MaxRetries = 0
+	if err := d.Download(); err == nil {
+		t.Fatal("cut compressed body was accepted")
+	}
+	if _, err := os.Stat(d.ProgressPath); !os.IsNotExist(err) {
+		t.Fatalf("progress file of compressed download exists: %v", err)
+	}
+
+	again := newTestDownloader(t, srv.URL)
+	again.FilePath = d.FilePath
+	again.RequestCompression = true
+	if err := again.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, again.FilePath, data)
+	req = requests()[1]
+	if req.Get("Range") != "" || req.Get("Accept-Encoding") != "gzip, deflate" {
+		t.Errorf("next run sent Range %q and Accept-Encoding %q, want fresh compressed download", req.Get("Range"), req.Get("Accept-Encoding"))
+	}
+}
@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Config holds options of Downloader, zero value of option keeps its default
+type Config struct {
+	URL             string
+	FilePath        string // empty takes file name from server response or url
+	OutputDir       string // directory for file named by server response
+	UseProgressFile bool
+	Overwrite       bool // replace existing complete file
+	AllowDevice     bool // allow FilePath to be block device, character device or named pipe
+
+	// directory for .part and progress file, completed file is moved to
+	// FilePath at the end, also across filesystems
+	StagingDir string
+
+	PersistEveryBytes int64 // also write progress file after this many bytes, 0 only every ProgressInterval
+
+	MaxBytes       int64         // refuse file bigger than this, 0 is unlimited
+	BufferSize     int64         // 0 uses 32 KiB
+	Buffers        *BufferPool   // read buffers shared with other downloads, pool of other size is ignored
+	Timeout        time.Duration // fail when no data arrive for this long, 0 waits forever
+	ConnectTimeout time.Duration // fail when connection can't be established this fast, 0 uses defaults
+	StallTimeout   time.Duration // retry when window this long brings less than StallSpeed once transfer started, 0 disables
+	StallSpeed     int64         // byte/s every StallTimeout window must bring, 0 uses 1 KiB/s
+	MaxRetries     int           // 0 uses 3 retries, negative disables retrying
+	RetryBaseDelay time.Duration // 0 uses 1s
+	SpeedLimit     int64         // byte/s, 0 is unlimited
+	AdaptiveLimit  bool          // SpeedLimit is upper cap lowered while link is congested
+	Limiter        *RateLimiter  // limit shared with other downloads
+	// HTTP statuses retried besides 5xx and 429
+	RetryStatusCodes []int
+
+	Headers            http.Header
+	Proxy              string
+	UseNetrc           bool // basic auth for request host from ~/.netrc
+	RequestCompression bool // ask for compressed transfer, such download can't be resumed
+
+	InsecureSkipVerify bool   // accept any server certificate
+	CACertFile         string // PEM file with additional trusted CA certificates
+	Quiet              bool
+	MessagesToStderr   bool // print messages and progress to stderr instead of stdout
+
+	Checksum    string // expected checksum as "<algorithm>:<hex>"
+	ChecksumURL string // manifest like SHA256SUMS with checksum of file, used when Checksum is empty
+
+	OnProgress       func(downloaded, total int64, bps float64, eta int64)
+	ProgressInterval time.Duration // 0 reports progress every second
+	OnComplete       func(path string, totalBytes int64, elapsed time.Duration)
+	OnError          func(err error)
+}
+
+// create Downloader from cfg, options are validated before anything is
+// downloaded
+func NewDownloaderWithConfig(cfg Config) (*Downloader, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("URL is required")
+	}
+	if cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("maximum file size can't be negative, got %d", cfg.MaxBytes)
+	}
+	if cfg.BufferSize < 0 {
+		return nil, fmt.Errorf("buffer size must be positive, got %d", cfg.BufferSize)
+	}
+	if cfg.Timeout < 0 || cfg.ConnectTimeout < 0 || cfg.StallTimeout < 0 || cfg.RetryBaseDelay < 0 {
+		return nil, fmt.Errorf("timeouts and retry delay can't be negative")
+	}
+	if cfg.StallSpeed < 0 {
+		return nil, fmt.Errorf("stall speed can't be negative, got %d", cfg.StallSpeed)
+	}
+	for _, code := range cfg.RetryStatusCodes {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("retry status code must be between 100 and 599, got %d", code)
+		}
+	}
+	if cfg.ProgressInterval < 0 {
+		return nil, fmt.Errorf("progress interval can't be negative, got %s", cfg.ProgressInterval)
+	}
+	if cfg.PersistEveryBytes < 0 {
+		return nil, fmt.Errorf("progress flush interval can't be negative, got %d", cfg.PersistEveryBytes)
+	}
+	if cfg.SpeedLimit < 0 {
+		return nil, fmt.Errorf("speed limit can't be negative, got %d", cfg.SpeedLimit)
+	}
+	if cfg.AdaptiveLimit && cfg.SpeedLimit == 0 {
+		return nil, fmt.Errorf("adaptive limit needs speed limit as its upper cap")
+	}
+	if cfg.Checksum != "" {
+		if _, _, err := parseChecksum(cfg.Checksum); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Proxy != "" {
+		if _, err := parseProxy(cfg.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CACertFile != "" {
+		if _, err := loadCACerts(cfg.CACertFile); err != nil {
+			return nil, err
+		}
+	}
+
+	d := NewDownloader(cfg.URL, cfg.FilePath, cfg.UseProgressFile)
+	if cfg.BufferSize > 0 {
+		d.BufferSize = cfg.BufferSize
+	}
+	d.Buffers = cfg.Buffers
+	d.MaxBytes = cfg.MaxBytes
+	d.Timeout = cfg.Timeout
+	d.ConnectTimeout = cfg.ConnectTimeout
+	d.StallTimeout = cfg.StallTimeout
+	d.StallSpeed = cfg.StallSpeed
+	if cfg.MaxRetries != 0 {
+		d.MaxRetries = max(cfg.MaxRetries, 0)
+	}
+	if cfg.RetryBaseDelay > 0 {
+		d.RetryBaseDelay = cfg.RetryBaseDelay
+	}
+	d.RetryStatusCodes = slices.Clone(cfg.RetryStatusCodes)
+	d.OutputDir = cfg.OutputDir
+	d.Overwrite = cfg.Overwrite
+	d.AllowDevice = cfg.AllowDevice
+	d.StagingDir = cfg.StagingDir
+	d.PersistEveryBytes = cfg.PersistEveryBytes
+	d.SpeedLimit = cfg.SpeedLimit
+	d.AdaptiveLimit = cfg.AdaptiveLimit
+	d.Limiter = cfg.Limiter
+	d.Headers = cfg.Headers.Clone()
+	d.Proxy = cfg.Proxy
+	d.UseNetrc = cfg.UseNetrc
+	d.RequestCompression = cfg.RequestCompression
+	d.InsecureSkipVerify = cfg.InsecureSkipVerify
+	d.CACertFile = cfg.CACertFile
+	d.Quiet = cfg.Quiet
+	d.MessagesToStderr = cfg.MessagesToStderr
+	d.ExpectedChecksum = cfg.Checksum
+	d.ChecksumURL = cfg.ChecksumURL
+	d.OnProgress = cfg.OnProgress
+	d.ProgressInterval = cfg.ProgressInterval
+	d.OnComplete = cfg.OnComplete
+	d.OnError = cfg.OnError
+	return d, nil
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// check whether output path is an existing block device (e.g. writing OS
+// image to /dev/sdb), such target is written directly without staging and
+// progress file is never created next to it inside /dev, character device
+// (/dev/stdout) or named pipe is written as stream without resume, all of
+// them need AllowDevice and symlink to them (/dev/stdout,
+// /dev/disk/by-id/...) is followed even without FollowSymlinks, device it
+// resolves to is opened instead of the symlink
+func (d *Downloader) prepareDevice() error {
+	d.isDevice = false
+	d.isPipe = false
+	d.devicePath = ""
+	info, err := os.Stat(d.FilePath)
+	if err != nil {
+		return nil
+	}
+	mode := info.Mode()
+	block := mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	if !block && mode&(os.ModeCharDevice|os.ModeNamedPipe) == 0 {
+		return nil
+	}
+
+	if block && !d.AllowDevice {
+		return fmt.Errorf("%s is a block device and writing to it destroys its content, enable AllowDevice to continue", d.FilePath)
+	}
+	if !d.AllowDevice {
+		return fmt.Errorf("%s is a device or named pipe, enable AllowDevice to write to it", d.FilePath)
+	}
+	if d.StagingDir != "" {
+		return fmt.Errorf("staging is not supported for device %s", d.FilePath)
+	}
+	d.devicePath, err = filepath.EvalSymlinks(d.FilePath)
+	if err != nil {
+		return err
+	}
+	if !block {
+		d.isPipe = true
+		return nil
+	}
+	d.isDevice = true
+
+	// resume is possible only with progress file explicitly placed elsewhere
+	if d.ProgressPath == "" || d.ProgressPath == d.FilePath+".progress" {
+		d.UseProgressFile = false
+		d.ProgressPath = ""
+	}
+	return nil
+}
+
+// open character device or named pipe at FilePath, it can't be truncated
+// or positioned, opening pipe waits for its reader
+func (d *Downloader) openPipe() (err error) {
+	d.OutputFile, err = os.OpenFile(d.devicePath, os.O_WRONLY, 0)
+	return err
+}
+
+// check that downloaded image fits on device and that device reports its
+// size, without known size it's not safe to seek on resume
+func (d *Downloader) checkDeviceSize() error {
+	size, err := d.OutputFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size <= 0 && d.Downloaded > 0 {
+		return fmt.Errorf("block device %s doesn't report its size, can't resume safely", d.FilePath)
+	}
+	if size > 0 && d.TotalSize > size {
+		return fmt.Errorf("download of %d bytes doesn't fit on block device %s of %d bytes", d.TotalSize, d.FilePath, size)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//go:build unix
+
+package downloader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// symlink to named pipe is followed without FollowSymlinks and data are
+// streamed into the pipe
+func TestDownloadToSymlinkedPipe(t *testing.T) {
+	data := testData(64 << 10)
+	dir := t.TempDir()
+	pipe := filepath.Join(dir, "pipe")
+	if err := syscall.Mkfifo(pipe, 0600); err != nil {
+		t.Skipf("can't create named pipe: %v", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(pipe, link); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan []byte)
+	go func() {
+		f, err := os.Open(pipe)
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer f.Close()
+		b, _ := io.ReadAll(f)
+		got <- b
+	}()
+
+	d := newTestDownloader(t, newFileServer(t, data).URL)
+	d.FilePath = link
+	d.AllowDevice = true
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; string(b) != string(data) {
+		t.Errorf("pipe received %d bytes, want %d", len(b), len(data))
+	}
+}
+
+// block device behind symlink (e.g. /dev/disk/by-id/...) is written at
+// path it resolves to, openFile would refuse the symlink itself
+func TestSymlinkedBlockDeviceIsResolved(t *testing.T) {
+	devices, _ := filepath.Glob("/dev/loop[0-9]*")
+	var device string
+	for _, path := range devices {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0 {
+			device = path
+			break
+		}
+	}
+	if device == "" {
+		t.Skip("no block device to point symlink at")
+	}
+	link := filepath.Join(t.TempDir(), "disk")
+	if err := os.Symlink(device, link); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDownloader("http://example.com/image.iso", link, true)
+	if err := d.prepareDevice(); err == nil {
+		t.Fatal("block device was accepted without AllowDevice")
+	}
+	d.AllowDevice = true
+	if err := d.prepareDevice(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.isDevice || d.dataPath() != device {
+		t.Errorf("data are written to %s (device %v), want %s", d.dataPath(), d.isDevice, device)
+	}
+	if d.UseProgressFile {
+		t.Error("progress file would be created next to device")
+	}
+}
@@ -1,223 +1,1269 @@
 package downloader
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// version of medow, sent in default User-Agent
+const Version = "0.1.0"
+
 type Downloader struct {
 	Url             string // url to download from
+	Source          Source // custom source of data, when nil Url is downloaded over HTTP
 	FilePath        string // path of file where data are written to
+	OutputDir       string // directory for file named by server response when FilePath is empty
 	ProgressPath    string // path of file where number of already downloaded bytes is stored
 	UseProgressFile bool   // true signals to use progress file
+	FollowSymlinks  bool   // allow writing through symlink at output and progress path
+	AllowDevice     bool   // allow FilePath to be block device (content gets overwritten), character device or named pipe
+	isDevice        bool
+	isPipe          bool   // FilePath is character device or named pipe written as stream
+	devicePath      string // FilePath of device or pipe with symlinks resolved
+
+	// when set, data and progress file are kept in this directory and the
+	// completed file is moved to FilePath only at the end
+	StagingDir string
+
+	// download whole file again when server ignores Range of resumed
+	// download, otherwise ErrRangeNotSupported is returned
+	RestartOnNoRange bool
+
+	// send Range also with fresh download for servers answering only 206,
+	// RangeUnit empty uses "bytes"
+	ForceRange bool
+	RangeUnit  string
+
+	// DownloadParallel uses several connections only for file bigger than
+	// ParallelThreshold, 0 uses 50 MiB, smaller file goes over one connection
+	AutoParallel      bool
+	ParallelThreshold int64
+
+	// alternative URLs of the same file, download continues from next one
+	// when current URL fails
+	MirrorURLs []string
+	nextMirror int
+
+	Headers http.Header    // extra headers sent with every request, e.g. API key
+	Cookies []*http.Cookie // cookies sent with every request, e.g. session of prior login
+	// stores cookies set by responses (also on redirects) and sends them
+	// with following requests, not used with Client
+	Jar http.CookieJar
+
+	// builds request used instead of one made from Url, Headers and
+	// credentials, e.g. signed request with its own method and body, it is
+	// called for every attempt and only Range and If-Range of resume are
+	// added, parallel download falls back to single connection
+	RequestFunc func(ctx context.Context) (*http.Request, error)
+
+	// ask for gzip or deflate compressed transfer of fresh download, data
+	// are decompressed on the fly so file gets original bytes, size is then
+	// unknown and progress indeterminate, compressed download is not
+	// resumable so progress file isn't kept and next run starts from zero,
+	// resume of plain partial data sends Range without Accept-Encoding
+	RequestCompression bool
+	compressed         bool // body of current attempt is being decompressed
+
+	// credentials sent with requests, BearerToken wins when both are set, they
+	// are sent to redirect target only when it is on the same host
+	Username    string
+	Password    string
+	BearerToken string
+
+	// take login and password for request host from .netrc when no
+	// credentials are set above, NetrcPath empty uses $NETRC or ~/.netrc
+	UseNetrc      bool
+	NetrcPath     string
+	netrcOnce     sync.Once
+	netrcMachines []netrcMachine
+
+	// when set, requests are made with this client and options below are not
+	// used, its CheckRedirect should keep Range header on redirects
+	Client *http.Client
+
+	MaxRedirects    int               // maximum number of redirects followed, 0 uses 10, negative disables following
+	LogRedirects    bool              // print every followed redirect
+	Transport       http.RoundTripper // used for requests instead of transport built from options below
+	MinTLSVersion   uint16            // lowest accepted TLS version, e.g. tls.VersionTLS12
+	HTTPVersion     string            // force HTTP version ("1.1" or "2"), empty negotiates
+	HostHTTPVersion map[string]string // HTTP version pinned per host name, overrides HTTPVersion
+	IdleConnTimeout time.Duration     // how long idle keep-alive connection is kept open, 0 uses 90s
+	Proxy           string            // proxy URL (http, https or socks5), empty uses HTTP_PROXY/HTTPS_PROXY/NO_PROXY
+	Timeout         time.Duration     // fail when no data arrive for this long, 0 waits forever
+	ConnectTimeout  time.Duration     // fail when TCP connection and TLS handshake take longer, 0 uses OS and transport defaults
+	MaxDuration     time.Duration     // fail when whole download takes longer, progress is kept for resume, 0 is unlimited
+	StallTimeout    time.Duration     // retry when window this long brings less than StallSpeed once transfer started, 0 disables
+	StallSpeed      int64             // byte/s every StallTimeout window must bring on average, 0 uses 1 KiB/s
+	client          *http.Client
+
+	// follow redirect from https to plain http, it's refused by default
+	// because credentials and data would travel unencrypted
+	AllowInsecureRedirect bool
+
+	// accept any server certificate, makes connection open to interception
+	// so use it only for trusted internal servers with self-signed certs
+	InsecureSkipVerify bool
+	CACertFile         string // PEM file with CA certificates trusted in addition to system ones
+
+	// called for fresh URL when server reports that Url expired, download then
+	// continues from already downloaded position using new URL
+	RefreshURL func(ctx context.Context) (string, error)
+
+	// decides whether download recorded in progress file may be resumed from
+	// Url, nil uses ShouldAllowResume, not consulted when RefreshURL is set
+	AllowResume func(url string, progressAge time.Duration) bool
+
+	ExpectedChecksum string    // checksum of file as "<algorithm>:<hex>", algorithm is md5, sha1, sha256 or sha512
+	hasher           hash.Hash // digest of data computed while downloading, nil when it can't be used
+	checksum         string    // hex digest computed by verification
+
+	// manifest like SHA256SUMS listing checksum of file, relative URL is
+	// resolved against Url, found digest fills empty ExpectedChecksum
+	ChecksumURL string
+
+	MaxRetries     int           // number of retries after transient failure, 0 disables retrying
+	RetryBaseDelay time.Duration // delay before first retry, doubles with every next one
+	MaxRetryAfter  time.Duration // longest wait honored from Retry-After of 429 or 503 response, 0 uses 1 minute
+	// HTTP statuses retried besides 5xx and 429, e.g. 408 or 425
+	RetryStatusCodes []int
+
+	// when set, downloaded file is verified against GPG signed checksum file
+	SignedChecksums *SignedChecksums
+	OnVerifyFailure VerifyFailurePolicy // what to do with data failing verification
+	remoteChanged   bool                // remote file changed during current download
 
 	Downloaded int64 // already downloaded bytes
 	TotalSize  int64 // full byte size of file
-	ResumedAt  int64
+	// size of file known in advance (e.g. from manifest), used for progress when
+	// server doesn't send Content-Length and checked after download
+	ExpectedSize int64
+	// refuse file bigger than this, e.g. endless stream from wrong URL, it is
+	// checked against announced size and while data arrive, 0 is unlimited
+	MaxBytes  int64
+	ResumedAt int64
+	// ETag or Last-Modified of remote file stored in progress file, resume
+	// sends it in If-Range so changed file is downloaded again from zero
+	ResumeValidator string
+
+	Overwrite    bool // replace existing complete file, without it such download fails
+	Timestamping bool // like wget -N, download only when remote file is newer than local one
+	// download only when server doesn't answer If-Modified-Since with 304,
+	// unlike Timestamping sizes and times are not compared otherwise
+	SkipIfUnchanged bool
+	// set mtime of downloaded file to Last-Modified sent by server, file
+	// keeps time of download when server didn't send valid one
+	PreserveTimestamp bool
+	Skipped           bool // set when Timestamping or SkipIfUnchanged found local file up to date
+
+	SniffContent bool      // detect type of data and warn when it doesn't match declared type
+	ContentType  string    // Content-Type sent by server
+	LastModified time.Time // Last-Modified sent by server, zero when unknown
+	DetectedType string    // type detected from first bytes of data when SniffContent is set
+
+	PassedMilliSc int64 // milliseconds since current download run started
+
+	startedAt  time.Time // start of Download call, retries included
+	received   int64     // bytes received from source since startedAt
+	knownTotal int64     // size announced by earlier response or progress file, 0 when unknown
+	endedAt    time.Time // end of Download call, zero while it runs
+	retries    int64     // attempts repeated after failure since startedAt
+	redirects  int64     // redirects followed since startedAt
+	lastRead   int64     // unix nanoseconds when data last arrived
 
-	PassedMilliSc int64
+	sessionMu sync.Mutex // guards startedAt and endedAt read by Stats
+
+	speedBits uint64 // last computed speed in byte/s, float64 bits for atomic access
+	eta       int64  // last computed ETA in seconds
+	speeds    speedHistory
+
+	// called every ProgressInterval with progress instead of printing it, bps
+	// is speed in byte/s and eta remaining seconds, total is 0 when size is unknown
+	OnProgress       func(downloaded, total int64, bps float64, eta int64)
+	ProgressInterval time.Duration // how often progress is printed and progress file written, 0 uses 1s
+
+	// called once when Download finishes, OnComplete only after file got its
+	// final name and passed verification, path is empty for DownloadTo
+	OnComplete func(path string, totalBytes int64, elapsed time.Duration)
+	OnError    func(err error)
+
+	progressCh   chan Progress // created by ProgressChan
+	progressChMu sync.Mutex
 
 	OutputFile   *os.File
 	ProgressFile *os.File
 
-	BufferSize int64 // size of buffer for chunks received from server
+	Preallocate bool  // reserve disk space for whole file before downloading
+	SpeedLimit  int64 // maximum download speed in byte/s, 0 is unlimited
+	// SpeedLimit is only upper cap, download backs off below it while other
+	// traffic competes for the link, used by single connection download
+	AdaptiveLimit bool
+	// limit shared with other downloaders, applies together with SpeedLimit
+	Limiter *RateLimiter
+
+	// size of buffer for chunks received from server, 0 or less uses 32 KiB,
+	// bigger buffer means fewer syscalls on fast links but more memory per
+	// download and coarser cancellation and speed limiting
+	BufferSize int64
+	Buffers    *BufferPool // optional pool of buffers shared with other downloaders
+
+	// when set, progress file is also written every time this many new bytes
+	// are received, independently of the time based tick, segment of
+	// parallel download syncs its part file instead because size of part
+	// is its progress
+	PersistEveryBytes int64
+	PersistSync       bool  // fsync output and progress file on byte triggered writes
+	persistedAt       int64 // byte count stored by last byte triggered write
+	progressMu        sync.Mutex
+	progressErr       error // first failed progress write, later writes are skipped
+
+	// abort when bytes downloaded repeatedly (over several Download calls)
+	// exceed this fraction of TotalSize, 0 disables the check
+	MaxRedownloadRatio float64
+	Redownloaded       int64 // bytes written again at offsets already downloaded before
+	highWater          int64 // furthest offset ever written to output file
+
+	// highWater of every segment of parallel download by its start offset
+	segmentHighWater map[int64]int64
+
+	Quiet   bool         // print nothing to stdout, errors are still returned
+	Verbose bool         // log requests, responses and retries to stderr
+	Logger  *slog.Logger // receives lifecycle events (requests, retries, completion), nil discards them
+	// print messages and progress to stderr, stdout is then left to caller,
+	// e.g. for printing only path of downloaded file
+	MessagesToStderr bool
+
+	segments []segment // segments of parallel download into part files
+
+	sink io.Writer // receives data instead of file during DownloadTo
+
+	probes *probeCache // inspect results shared by downloads of Queue
+
+	display *progressLine // set when progress is rendered by MultiProgress
+	bar     progressBar   // progress output when display is not set
+	gate    pauseGate
+	clock   // time of retries and rate limit, replaced by tests
 }
 
 //const bufferSize = 8192
 
-// create new Downloader object
+// create new Downloader object, empty filepath means name of file is taken
+// from server response or url, leading ~ and $VAR in filepath are expanded
 func NewDownloader(url string, filepath string, useProgressFile bool) *Downloader {
 	d := &Downloader{}
-	d.FilePath = filepath
+	d.FilePath = expandPath(filepath)
 	d.Url = url
-	d.ProgressPath = filepath + ".progress"
+	if d.FilePath != "" {
+		d.ProgressPath = d.FilePath + ".progress"
+	}
 	d.UseProgressFile = useProgressFile
-	d.BufferSize = 32768
+	d.BufferSize = defaultBufferSize
+	d.MinTLSVersion = tls.VersionTLS12
+	d.MaxRetries = 3
+	d.RetryBaseDelay = defaultRetryBaseDelay
 	return d
 
 }
 
-// reads progress from .progress file if progress is enabled
-func (d *Downloader) ReadProgress() {
-	data, err := os.ReadFile(d.ProgressPath)
+// expand leading ~ to home directory and $VAR or ${VAR} to value of
+// environment variable, ~ elsewhere in path is kept as it is
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return os.ExpandEnv(path)
+}
+
+// FilePath "-" streams data to stdout, e.g. for piping into other tool
+func (d *Downloader) toStdout() bool {
+	return d.FilePath == "-"
+}
+
+// data go to stdout, to writer of DownloadTo or to pipe instead of file,
+// such stream can't be resumed by later run
+func (d *Downloader) streaming() bool {
+	return d.sink != nil || d.toStdout() || d.isPipe
+}
+
+// prepare everything derived from FilePath before files are touched
+func (d *Downloader) preparePaths() error {
+	if d.sink == nil && !d.toStdout() {
+		if err := d.prepareDevice(); err != nil {
+			return err
+		}
+	}
+
+	// stream can't be resumed later, so there's no progress file
+	if d.streaming() {
+		d.UseProgressFile = false
+		d.ProgressPath = ""
+		return nil
+	}
+
+	if d.ProgressPath == "" {
+		d.ProgressPath = d.FilePath + ".progress"
+	}
+
+	if d.StagingDir != "" {
+		if err := os.MkdirAll(d.StagingDir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// progress file is relocated together with data, custom progress path is
+	// kept unless data are staged
+	if d.StagingDir != "" || (!d.isDevice && d.ProgressPath == d.FilePath+".progress") {
+		d.ProgressPath = d.dataPath() + ".progress"
+	}
+
+	// missing parent directories of output and progress file are created
+	if !d.isDevice {
+		if err := os.MkdirAll(filepath.Dir(d.FilePath), 0755); err != nil {
+			return err
+		}
+	}
+	if d.ProgressPath != "" {
+		if err := os.MkdirAll(filepath.Dir(d.ProgressPath), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refuse fresh download over complete file at FilePath, partial download
+// is resumed and conditional download decides on its own whether to replace file
+func (d *Downloader) checkOverwrite() error {
+	if d.Overwrite || d.conditional() || d.Downloaded > 0 || d.isDevice || d.streaming() {
+		return nil
+	}
+	info, err := os.Stat(d.FilePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	return fmt.Errorf("%s: %w, use -force to overwrite it", d.FilePath, ErrFileExists)
+}
+
+// suffix of file holding data until download is complete
+const partSuffix = ".part"
+
+// path of file where data are written during download, it gets final name
+// only when complete so interrupted download is never mistaken for whole file,
+// block device is written directly
+func (d *Downloader) dataPath() string {
+	if d.isDevice {
+		return d.devicePath
+	}
+	if d.streaming() {
+		return d.FilePath
+	}
+	if d.StagingDir != "" {
+		return filepath.Join(d.StagingDir, filepath.Base(d.FilePath)) + partSuffix
+	}
+	return d.FilePath + partSuffix
+}
+
+// open file for writing, unless FollowSymlinks is set a symlink at path is
+// refused so nobody can redirect our writes through it (symlink attack)
+func (d *Downloader) openFile(path string, flag int) (*os.File, error) {
+	if !d.FollowSymlinks {
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("refusing to write through symlink %s, enable FollowSymlinks to allow it", path)
+		}
+		flag |= oNoFollow
+	}
+	return os.OpenFile(path, flag, 0644)
+}
+
+// number of bytes to hash on verification, device is larger than image
+// written to it so only downloaded part is verified, 0 means whole file
+func (d *Downloader) verifySize() int64 {
+	if d.isDevice {
+		return atomic.LoadInt64(&d.Downloaded)
+	}
+	return 0
+}
+
+// resume position checked against size of partial file, shorter file (e.g.
+// truncated by crash) would leave gap of garbage, longer one has data written
+// before progress was stored, preallocated file is longer by design
+func (d *Downloader) partialSize(recorded int64) int64 {
+	if d.isDevice || d.streaming() {
+		return recorded
+	}
+	info, err := os.Stat(d.dataPath())
 	if err != nil {
-		d.Downloaded = 0
-		return
+		return 0
 	}
-	parsedNum, err := strconv.ParseInt(string(data), 10, 64)
-	if err != nil || parsedNum < 0 {
-		d.Downloaded = 0
+	size := info.Size()
+	if size < recorded || (size > recorded && !d.Preallocate) {
+		d.debugf("progress file says %d bytes but %s has %d, resuming from %d", recorded, d.dataPath(), size, size)
+		return size
+	}
+	return recorded
+}
+
+// reads progress from .progress file if progress is enabled, file holds
+// byte count optionally followed by validator on second line
+func (d *Downloader) ReadProgress() {
+	d.ResumeValidator = ""
+	state, err := LoadProgress(d.ProgressPath)
+	if err != nil {
+		atomic.StoreInt64(&d.Downloaded, 0)
 		return
 	}
-	d.Downloaded = parsedNum
+	atomic.StoreInt64(&d.Downloaded, d.partialSize(state.Downloaded))
 	d.ResumedAt = d.Downloaded
+	d.ResumeValidator = state.Validator
+	if state.Total > 0 {
+		d.knownTotal = state.Total
+	}
 
 }
 
-// creation of GET request based on input url
-func (d *Downloader) CreateRequest() (*http.Request, error) {
+// create request for url with options common to all requests, headers set
+// by downloader itself (like Range) are set later so they win over Headers
+func (d *Downloader) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	return d.newRequestURL(ctx, method, d.Url)
+}
 
-	req, err := http.NewRequest("GET", d.Url, nil)
+// same as newRequest but for given url
+func (d *Downloader) newRequestURL(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// read progress file if enabled and ask server to send chunks from selected position
+	for key, values := range d.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	for _, cookie := range d.Cookies {
+		req.AddCookie(cookie)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "medow/"+Version)
+	}
+
+	if d.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.BearerToken)
+	} else if d.Username != "" || d.Password != "" {
+		req.SetBasicAuth(d.Username, d.Password)
+	} else if login, password, ok := d.netrcAuth(req.URL.Hostname()); ok {
+		req.SetBasicAuth(login, password)
+	}
+	return req, nil
+}
+
+// set header sent with every request, replacing its previous values
+func (d *Downloader) SetHeader(key, value string) {
+	if d.Headers == nil {
+		d.Headers = make(http.Header)
+	}
+	d.Headers.Set(key, value)
+}
+
+// creation of GET request based on input url, it continues from position
+// in progress file when progress is enabled
+func (d *Downloader) CreateRequest() (*http.Request, error) {
 	if d.UseProgressFile {
 		d.ReadProgress()
-		if d.Downloaded > 0 {
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.Downloaded))
+	}
+	return d.createRequest(context.Background())
+}
+
+// same as CreateRequest, request is bound to ctx
+func (d *Downloader) createRequest(ctx context.Context) (*http.Request, error) {
+
+	var req *http.Request
+	var err error
+	if d.RequestFunc != nil {
+		req, err = d.RequestFunc(ctx)
+		if err == nil && req == nil {
+			err = errors.New("RequestFunc returned no request")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("building request: %w", err)
+		}
+		req = req.WithContext(ctx)
+	} else {
+		req, err = d.newRequest(ctx, "GET")
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	// Range applies to uncompressed data only, so resumed request doesn't
+	// ask for compression
+	if d.RequestCompression && d.Downloaded == 0 && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
 
+	// ask server to send chunks from position chosen by resolveStartState
+	if d.Downloaded > 0 || d.ForceRange {
+		req.Header.Set("Range", fmt.Sprintf("%s=%d-", d.rangeUnit(), d.Downloaded))
+		if d.Downloaded > 0 && d.ResumeValidator != "" {
+			req.Header.Set("If-Range", d.ResumeValidator)
+		}
+	}
+
+	// local copy is compared with remote file only when nothing is resumed
+	if d.conditional() {
+		d.setIfModifiedSince(req)
 	}
 
 	return req, nil
 
 }
 
-// download chunk of file from server
-func (d *Downloader) DownloadChunks(body io.Reader) error {
-	buf := make([]byte, d.BufferSize)
+// unit of Range requests
+func (d *Downloader) rangeUnit() string {
+	if d.RangeUnit != "" {
+		return d.RangeUnit
+	}
+	return "bytes"
+}
+
+// open output file and position it at resume offset, file is closed on error
+func (d *Downloader) openOutput() (err error) {
+	d.OutputFile, err = d.openFile(d.dataPath(), os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			d.OutputFile.Close()
+		}
+	}()
+
+	if d.isDevice {
+		if err := d.checkDeviceSize(); err != nil {
+			return err
+		}
+	}
+
+	// fresh download must not leave tail of older, longer file behind
+	if d.Downloaded == 0 && !d.isDevice {
+		if err := d.OutputFile.Truncate(0); err != nil {
+			return err
+		}
+	}
+
+	if err := d.checkFreeSpace(); err != nil {
+		return err
+	}
+	if d.Preallocate {
+		if err := d.preallocate(); err != nil {
+			return err
+		}
+	}
+
+	// seek to last downloaded byte in output file
+	_, err = d.OutputFile.Seek(d.Downloaded, 0)
+	return err
+}
+
+// write chunk to output file and account for it in downloaded bytes
+func (d *Downloader) writeChunk(chunk []byte) error {
+	var w io.Writer = d.OutputFile
+	if d.sink != nil {
+		w = d.sink
+	}
+	if err := d.checkChunk(len(chunk)); err != nil {
+		return err
+	}
+	_, err := w.Write(chunk)
+	if err != nil {
+		return err
+	}
+	if d.hasher != nil {
+		d.hasher.Write(chunk)
+	}
+	current, err := d.countChunk(atomic.LoadInt64(&d.Downloaded), len(chunk), &d.highWater)
+	if err != nil {
+		return err
+	}
+
+	if d.PersistEveryBytes > 0 && current-d.persistedAt >= d.PersistEveryBytes {
+		return d.persistBytes(current)
+	}
+	return nil
+}
+
+// checks of chunk about to be written, shared by single stream and segments
+func (d *Downloader) checkChunk(n int) error {
+	return d.checkMaxBytes(atomic.LoadInt64(&d.Downloaded) + int64(n))
+}
+
+// account for n bytes just written at offset by stream whose furthest
+// written offset is highWater, returns downloaded bytes of whole file
+func (d *Downloader) countChunk(offset int64, n int, highWater *int64) (int64, error) {
+	current := atomic.AddInt64(&d.Downloaded, int64(n))
+	atomic.AddInt64(&d.received, int64(n))
+	atomic.StoreInt64(&d.lastRead, time.Now().UnixNano())
+	return current, d.trackRedownload(offset, int64(n), highWater)
+}
+
+// fail when file of given size would exceed MaxBytes
+func (d *Downloader) checkMaxBytes(size int64) error {
+	if d.MaxBytes > 0 && size > d.MaxBytes {
+		return fmt.Errorf("%w: file would have more than %s", ErrMaxBytesExceeded, FormatSize(d.MaxBytes, true))
+	}
+	return nil
+}
+
+// byte triggered progress write, output is synced first (when enabled) so
+// stored byte count never points past data that reached the disk
+func (d *Downloader) persistBytes(current int64) error {
+	if d.PersistSync {
+		if err := d.OutputFile.Sync(); err != nil {
+			return err
+		}
+	}
+	d.writeProgress(current, d.PersistSync)
+	d.persistedAt = current
+	return nil
+}
+
+// count bytes written below highWater of their stream, those were already
+// downloaded once and are transferred again because of imperfect resume,
+// segments of parallel download run concurrently so the total is atomic
+func (d *Downloader) trackRedownload(offset, n int64, highWater *int64) error {
+	end := offset + n
+	redownloaded := atomic.LoadInt64(&d.Redownloaded)
+	if offset < *highWater {
+		redownloaded = atomic.AddInt64(&d.Redownloaded, min(end, *highWater)-offset)
+	}
+	*highWater = max(*highWater, end)
+
+	if d.MaxRedownloadRatio > 0 && d.TotalSize > 0 &&
+		float64(redownloaded) > d.MaxRedownloadRatio*float64(d.TotalSize) {
+		return &RedownloadLimitError{Redownloaded: redownloaded, TotalSize: d.TotalSize}
+	}
+	return nil
+}
+
+// create and perform request, when server signals that URL expired and
+// RefreshURL is set, request is repeated once with fresh URL
+func (d *Downloader) fetch(ctx context.Context, httpClient *http.Client) (*http.Response, error) {
+	req, err := d.createRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(httpClient, req)
+	if err != nil || d.RefreshURL == nil || !urlExpired(resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	url, err := d.RefreshURL(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("refreshing expired URL: %w", err)
+	}
+	d.Url = url
+
+	// new request also continues from downloaded position via Range
+	req, err = d.createRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.do(httpClient, req)
+}
+
+// signed URLs (S3 presigned, CDN tokens) answer with 403 or 410 once expired
+func urlExpired(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusGone
+}
+
+// download chunk of file from server, cancelling ctx stops reading promptly
+func (d *Downloader) DownloadChunks(ctx context.Context, body io.Reader) error {
+	buf, release := d.getBuffer()
+	defer release()
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		n, readErr := body.Read(buf)
 		if n > 0 {
-			_, writeErr := d.OutputFile.Write(buf[:n])
-			if writeErr != nil {
+			if writeErr := d.writeChunk(buf[:n]); writeErr != nil {
 				return writeErr
 			}
-			atomic.AddInt64(&d.Downloaded, int64(n))
 		}
 		if readErr != nil {
-			if readErr == io.EOF {
-				return nil
-			}
-			return readErr
+			return readResult(ctx, readErr)
 		}
 	}
 
 }
 
-func (d *Downloader) DownloadChunksWithLimit(body io.Reader, maxSpeedBytes int64) error {
-	buf := make([]byte, d.BufferSize)
+// map error ending read loop to its result, EOF is success and failure
+// caused by cancelled request is reported as cancellation
+func readResult(ctx context.Context, readErr error) error {
+	if readErr == io.EOF {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return readErr
+}
 
-	startTime := time.Now()
-	var totalBytes int64 = 0
+// sleep for duration or until ctx is cancelled
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// same as DownloadChunks but throughput is limited to maxSpeedBytes byte/s
+// and by shared Limiter, maxSpeedBytes <= 0 means no own limit
+func (d *Downloader) DownloadChunksWithLimit(ctx context.Context, body io.Reader, maxSpeedBytes int64) error {
+	if maxSpeedBytes <= 0 && d.Limiter == nil {
+		return d.DownloadChunks(ctx, body)
+	}
+
+	buf, release := d.getBuffer()
+	defer release()
+
+	// reads are not larger than one second worth of data so low limits
+	// don't arrive in big bursts
+	if maxSpeedBytes > 0 && int64(len(buf)) > maxSpeedBytes {
+		buf = buf[:maxSpeedBytes]
+	}
+	if d.Limiter != nil && int64(len(buf)) > d.Limiter.rate {
+		buf = buf[:d.Limiter.rate]
+	}
+	var bucket *tokenBucket
+	if maxSpeedBytes > 0 {
+		bucket = newTokenBucket(maxSpeedBytes, len(buf), d.now())
+	}
 
 	for {
-		n, readErr := body.Read(buf)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size := len(buf)
+		if bucket != nil {
+			size = bucket.readSize(d.now(), size)
+		}
+		n, readErr := body.Read(buf[:d.Limiter.readSize(size)])
 		if n > 0 {
-			_, writeErr := d.OutputFile.Write(buf[:n])
-			if writeErr != nil {
+			if writeErr := d.writeChunk(buf[:n]); writeErr != nil {
 				return writeErr
 			}
-			atomic.AddInt64(&d.Downloaded, int64(n))
-			totalBytes += int64(n)
-
-			// Calculate expected duration for totalBytes
-			expectedDuration := time.Duration(float64(totalBytes)/float64(maxSpeedBytes)) * time.Second
-			elapsed := time.Since(startTime)
-
-			if sleepDuration := expectedDuration - elapsed; sleepDuration > 0 {
-				time.Sleep(sleepDuration)
+			if bucket != nil {
+				if wait := bucket.take(d.now(), n); wait > 0 {
+					if err := d.sleep(ctx, wait); err != nil {
+						return err
+					}
+				}
+			}
+			if err := d.Limiter.WaitN(ctx, n); err != nil {
+				return err
 			}
 		}
 		if readErr != nil {
-			if readErr == io.EOF {
-				return nil
-			}
-			return readErr
+			return readResult(ctx, readErr)
 		}
 	}
 }
 
 // this function actually starts and manages downloading
 func (d *Downloader) Download() error {
+	return d.DownloadContext(context.Background())
+}
+
+// same as Download, cancelling ctx stops download and stores progress so it
+// can be resumed later, ctx.Err() is returned in such case
+func (d *Downloader) DownloadContext(ctx context.Context) (err error) {
+
+	// tracked downloads report their final state to shared progress display
+	if d.display != nil {
+		defer func() { d.display.finish(err) }()
+	}
+	defer func() { d.notify(err) }()
+	defer func() {
+		if err != nil {
+			d.logger().Error("download failed", "url", d.Url, "error", err)
+		}
+	}()
+
+	ctx, cancel := d.limitDuration(ctx)
+	defer cancel()
+	defer func() { err = durationErr(ctx, err) }()
+	defer d.closeProgress()
+	d.startSession()
+	return d.downloadStream(ctx)
+}
+
+// single stream download with retries and mirrors, data failing
+// verification are handled according to OnVerifyFailure
+func (d *Downloader) downloadStream(ctx context.Context) error {
+
+	// without progress file every download starts from zero
+	if !d.UseProgressFile {
+		atomic.StoreInt64(&d.Downloaded, 0)
+		d.ResumedAt = 0
+	}
+
+	d.remoteChanged = false
+	err := d.downloadWithRetry(ctx)
+	return d.handleVerifyFailure(err, func() error { return d.downloadWithRetry(ctx) })
+}
+
+// download into w instead of file, e.g. small file kept in memory, there's
+// no progress file and no resume by later call, retries continue the
+// stream and checksum is verified as in Download
+func (d *Downloader) DownloadTo(w io.Writer) error {
+	return d.DownloadToContext(context.Background(), w)
+}
 
-	// create HTTP client & request
-	httpClient := &http.Client{}
-	req, err := d.CreateRequest()
+// same as DownloadTo, cancelling ctx stops download
+func (d *Downloader) DownloadToContext(ctx context.Context, w io.Writer) error {
+	d.sink = w
+	defer func() { d.sink = nil }()
+	d.UseProgressFile = false
+	return d.DownloadContext(ctx)
+}
+
+// single download attempt, used by Download
+func (d *Downloader) download(ctx context.Context) (err error) {
+
+	// progress is read first so source opens data from resume position,
+	// without file name it can be read only once name is known from response
+	named := d.FilePath != "" || d.sink != nil
+	if named {
+		if err := d.preparePaths(); err != nil {
+			return err
+		}
+		if _, err := d.resolveStartState(); err != nil {
+			return err
+		}
+	} else {
+		atomic.StoreInt64(&d.Downloaded, 0)
+	}
+
+	// watchdog context is used only for this attempt, retry gets new one
+	ctx, watchdog := d.watchIdle(ctx)
+	defer watchdog.stop()
+
+	src := d.source()
+	body, size, err := src.Open(ctx, d.Downloaded)
+	err = watchdog.err(ctx, err)
+	if err == errUpToDate {
+		d.Skipped = true
+		d.printf("File %s is up to date, skipping\n", d.FilePath)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	// perform HTTP request
-	resp, err := httpClient.Do(req)
+
+	if !named {
+		if d.FilePath == "" {
+			body.Close()
+			return fmt.Errorf("output file path is required for this source")
+		}
+		if err := d.preparePaths(); err != nil {
+			body.Close()
+			return err
+		}
+
+		// resolved file may be partially downloaded already, request is
+		// then repeated from its resume position
+		offset, err := d.resolveStartState()
+		if err != nil {
+			body.Close()
+			return err
+		}
+		if offset > 0 {
+			body.Close()
+			body, size, err = src.Open(ctx, offset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	defer body.Close()
+
+	if size >= 0 {
+		d.TotalSize = d.Downloaded + size
+	} else {
+		d.TotalSize = 0
+	}
+
+	// resumed data must belong to the same file, other mirror or CDN node
+	// serving file of other size would get its bytes stitched to old ones
+	if size >= 0 && d.Downloaded > 0 && d.knownTotal > 0 && d.TotalSize != d.knownTotal {
+		return &SizeChangedError{Expected: d.knownTotal, Got: d.TotalSize}
+	}
+	if size >= 0 {
+		d.knownTotal = d.TotalSize
+	}
+
+	// announced size over limit fails before any data are transferred
+	if size >= 0 {
+		if err := d.checkMaxBytes(d.TotalSize); err != nil {
+			return err
+		}
+	}
+
+	// expected size stands in for size server didn't send, but it must
+	// agree with size server did send
+	if d.ExpectedSize > 0 {
+		if size >= 0 && d.TotalSize != d.ExpectedSize {
+			return &SizeMismatchError{Expected: d.ExpectedSize, Got: d.TotalSize}
+		}
+		d.TotalSize = d.ExpectedSize
+	}
+
+	// file isn't created when its checksum can't be found
+	if err := d.resolveChecksumURL(ctx); err != nil {
+		return err
+	}
+
+	// streamed data go to stdout or writer which have nothing to truncate
+	// or seek
+	switch {
+	case d.sink != nil:
+		d.OutputFile = nil
+	case d.toStdout():
+		d.OutputFile = os.Stdout
+	case d.isPipe:
+		if err := d.openPipe(); err != nil {
+			return err
+		}
+		defer d.OutputFile.Close()
+	default:
+		if err := d.openOutput(); err != nil {
+			return err
+		}
+		defer d.OutputFile.Close()
+	}
+
+	// open progress file for writing and also prepare closing, resume of
+	// decompressed data isn't possible so stale progress is dropped instead
+	if d.compressed && d.ProgressPath != "" {
+		os.Remove(d.ProgressPath)
+	} else if d.ProgressPath != "" {
+		if err := d.openProgressFile(); err != nil {
+			return err
+		}
+		defer d.closeProgressFile(false)
+	}
+
+	d.persistedAt = d.Downloaded
+	d.startHasher()
+
+	stopChan := make(chan struct{}) // this channel signal end of downloading
+	d.ManageProgressPrinter(stopChan)
+	defer close(stopChan)
+
+	d.logger().Info("download started", "url", d.Url, "file", d.FilePath, "offset", d.Downloaded, "size", d.TotalSize)
+
+	// banner would break lines of shared progress display
+	if d.Url != "" {
+		d.printf("Downloading from: %s\n", d.Url)
+		if d.toStdout() {
+			d.printf("Downloading to: stdout\n")
+		} else if d.sink == nil {
+			d.printf("Downloading to: ./%s\n", d.FilePath)
+		}
+	}
+
+	// type can be detected only from start of file, not from resumed part
+	var data io.Reader = d.pausable(ctx, watchdog, watchdog.reader(body))
+	if d.SniffContent && d.Downloaded == 0 {
+		data = d.sniffContent(data)
+	}
+
+	// download all file chunks
+	if d.AdaptiveLimit && d.SpeedLimit > 0 {
+		err = d.DownloadChunksAdaptive(ctx, data, d.SpeedLimit)
+	} else {
+		err = d.DownloadChunksWithLimit(ctx, data, d.SpeedLimit)
+	}
+	err = watchdog.err(ctx, err)
+
+	// body ending before announced size was reached is retried from the
+	// position it reached, longer one means announced size was wrong
+	got := atomic.LoadInt64(&d.Downloaded)
+	if size >= 0 && got != d.TotalSize && (err == nil || errors.Is(err, io.ErrUnexpectedEOF)) {
+		err = &ShortReadError{Expected: d.TotalSize, Got: got}
+	}
+
+	// interrupted download stores exact position so resume loses nothing
 	if err != nil {
+		d.OutputFile.Sync()
+		d.writeProgress(atomic.LoadInt64(&d.Downloaded), true)
+		if perr := d.progressWriteErr(); perr != nil {
+			err = errors.Join(err, perr)
+		}
 		return err
 	}
 
-	defer resp.Body.Close()
+	// device caches must be flushed before image is considered written
+	if err == nil && d.isDevice {
+		err = d.OutputFile.Sync()
+	}
+	if err == nil {
+		err = d.trimPreallocated()
+	}
 
-	// force quit when servers response in negative
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("bad HTTP status %s\n", resp.Status)
+	if err == nil {
+		err = d.finalize()
 	}
 
-	// force quit when server doesn't support partial downloads
-	if d.Downloaded > 0 && resp.StatusCode != http.StatusPartialContent {
-		fmt.Println("Server doesn't support partial downloads, please remove file: ", d.ProgressPath)
+	return err
 
-		return fmt.Errorf("Server does not support partial downloads, if you want to continue please remove file: %s\n", d.ProgressPath)
+}
 
+// verify downloaded data, move them to final location, stamp its time and
+// clean up progress file, called once all data were received, on error
+// progress file is kept so download can be resumed or investigated
+func (d *Downloader) finalize() error {
+	if d.ExpectedSize > 0 && d.Downloaded != d.ExpectedSize {
+		return &SizeMismatchError{Expected: d.ExpectedSize, Got: d.Downloaded}
 	}
-	// get file size from http header that was send by server
-	contentLenStr := resp.Header.Get("Content-Length")
-	if contentLenStr != "" {
-		contentLen, err := strconv.ParseInt(contentLenStr, 10, 64)
+
+	// progress file is kept on failed verification so file can be investigated
+	if d.ExpectedChecksum != "" {
+		if err := d.verifyChecksum(d.dataPath()); err != nil {
+			return err
+		}
+	}
+	if d.SignedChecksums != nil {
+		err := d.SignedChecksums.verify(d.dataPath(), filepath.Base(d.FilePath), d.verifySize())
 		if err != nil {
 			return err
 		}
-		d.TotalSize = d.Downloaded + contentLen
 	}
 
-	// open output file for writing and also prepare closing
-	d.OutputFile, err = os.OpenFile(d.FilePath, os.O_CREATE|os.O_WRONLY, 0644)
+	// part file is moved to its final location only when complete
+	if d.dataPath() != d.FilePath {
+		d.OutputFile.Close()
+		if err := moveFile(d.dataPath(), d.FilePath); err != nil {
+			return err
+		}
+	}
+
+	if (d.conditional() || d.PreserveTimestamp) && !d.streaming() && !d.isDevice {
+		if err := d.stampFile(); err != nil {
+			return err
+		}
+	}
+
+	d.closeProgressFile(true)
+	d.logger().Info("download completed", "url", d.Url, "file", d.FilePath, "bytes", d.Downloaded)
+	d.printf("Download completed.\n")
+	d.printSummary()
+	return nil
+}
+
+// start counting time and bytes reported by printSummary
+func (d *Downloader) startSession() {
+	d.sessionMu.Lock()
+	d.startedAt = time.Now()
+	d.endedAt = time.Time{}
+	d.sessionMu.Unlock()
+	atomic.StoreInt64(&d.received, 0)
+	atomic.StoreInt64(&d.lastRead, time.Now().UnixNano())
+	d.knownTotal = 0
+	atomic.StoreInt64(&d.retries, 0)
+	atomic.StoreInt64(&d.redirects, 0)
+	d.speeds.reset()
+}
+
+// stop clock of Stats
+func (d *Downloader) endSession() {
+	d.sessionMu.Lock()
+	defer d.sessionMu.Unlock()
+	d.endedAt = time.Now()
+}
+
+// reports whether no data arrived for whole speed window, progress then
+// shows stall instead of slowly decreasing speed
+func (d *Downloader) stalled() bool {
+	last := time.Unix(0, atomic.LoadInt64(&d.lastRead))
+	return !d.Paused() && time.Since(last) >= speedWindow
+}
+
+// stop clock of Stats and run OnComplete or OnError with final result of
+// download
+func (d *Downloader) notify(err error) {
+	d.endSession()
 	if err != nil {
-		return err
+		if d.OnError != nil {
+			d.OnError(err)
+		}
+		return
+	}
+	if d.OnComplete != nil {
+		path := d.FilePath
+		if d.sink != nil {
+			path = ""
+		}
+		d.OnComplete(path, d.Downloaded, time.Since(d.startedAt))
 	}
-	defer d.OutputFile.Close()
+}
 
-	// seek to last downloaded byte in output file
-	_, err = d.OutputFile.Seek(d.Downloaded, 0)
+// print bytes received, elapsed time and average speed of finished
+// download, resumed download also shows size of whole file
+func (d *Downloader) printSummary() {
+	if d.startedAt.IsZero() {
+		return
+	}
+	elapsed := time.Since(d.startedAt)
+	received := atomic.LoadInt64(&d.received)
+	speed := 0.0
+	if elapsed > 0 {
+		speed = float64(received) / elapsed.Seconds()
+	}
+
+	if received < d.Downloaded {
+		d.printf("Downloaded %s of %s file in %s (%s)\n",
+			FormatSize(received, true), FormatSize(d.Downloaded, true), elapsed.Round(time.Millisecond), FormatSpeed(speed))
+	} else {
+		d.printf("Downloaded %s in %s (%s)\n",
+			FormatSize(received, true), elapsed.Round(time.Millisecond), FormatSpeed(speed))
+	}
+}
+
+// store number of downloaded bytes to progress file, ticker and byte
+// triggered persisting can both call this so access is serialized
+func (d *Downloader) writeProgress(current int64, sync bool) {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+
+	if d.ProgressFile == nil || d.progressErr != nil {
+		return
+	}
+	content := d.formatProgress(current)
+	_, err := d.ProgressFile.Seek(0, 0)
+	if err == nil {
+		err = d.ProgressFile.Truncate(0)
+	}
+	if err == nil {
+		_, err = d.ProgressFile.WriteString(content)
+	}
+	if err == nil && sync {
+		err = d.ProgressFile.Sync()
+	}
+
+	// full disk or read-only filesystem would otherwise be noticed only
+	// when resume starts from stale position
 	if err != nil {
-		return err
+		d.progressErr = &ProgressWriteError{Path: d.ProgressPath, Err: err}
+		d.logger().Error("progress file write failed", "file", d.ProgressPath, "error", err)
+		if !d.Quiet {
+			fmt.Fprintf(os.Stderr, "\nwarning: %v, download can't be resumed from here\n", d.progressErr)
+		}
 	}
+}
+
+// first progress write error of current attempt
+func (d *Downloader) progressWriteErr() error {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+	return d.progressErr
+}
 
-	// open progress file for writing and also prepare closing
-	d.ProgressFile, err = os.OpenFile(d.ProgressPath, os.O_CREATE|os.O_RDWR, 0644)
+// set progress file written by ticker and byte triggered persisting, it is
+// replaced and closed only under progressMu so no write can reach file
+// after it was closed or removed
+func (d *Downloader) openProgressFile() error {
+	f, err := d.openFile(d.ProgressPath, os.O_CREATE|os.O_RDWR)
 	if err != nil {
 		return err
 	}
-	defer d.ProgressFile.Close()
+	d.progressMu.Lock()
+	d.ProgressFile = f
+	d.progressErr = nil
+	d.progressMu.Unlock()
+	return nil
+}
 
-	stopChan := make(chan struct{}) // this channel signal end of downloading
-	d.ManageProgressPrinter(stopChan)
+// close progress file so later writes are no-op, remove also deletes it
+// once download is complete
+func (d *Downloader) closeProgressFile(remove bool) {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
 
-	fmt.Printf("Downloading from: %s\n", d.Url)
-	fmt.Printf("Downloading to: ./%s\n", d.FilePath)
+	if d.ProgressFile != nil {
+		d.ProgressFile.Close()
+		d.ProgressFile = nil
+	}
+	// complete file is not affected, but stale progress file would make
+	// next download of the same name resume wrongly
+	if remove && d.ProgressPath != "" {
+		if err := os.Remove(d.ProgressPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			d.debugf("removing progress file failed: %v", err)
+			d.logger().Warn("progress file not removed", "file", d.ProgressPath, "error", err)
+		}
+	}
+}
 
-	// download all file chunks
-	err = d.DownloadChunks(resp.Body)
+// latest download speed in byte/s computed by progress goroutine
+func (d *Downloader) CurrentSpeed() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&d.speedBits))
+}
 
-	if err == nil {
-		os.Remove(d.ProgressPath)
-		fmt.Println("Download completed.")
-	}
+// latest estimated remaining time in seconds computed by progress goroutine
+func (d *Downloader) ETA() int64 {
+	return atomic.LoadInt64(&d.eta)
+}
 
-	return err
+// time speed is averaged over, independent of ProgressInterval
+const speedWindow = 5 * time.Second
 
+// tick of progress goroutine
+func (d *Downloader) progressInterval() time.Duration {
+	if d.ProgressInterval > 0 {
+		return d.ProgressInterval
+	}
+	return time.Second
+}
+
+// downloaded byte count at some moment
+type speedSample struct {
+	at    time.Time
+	bytes int64
 }
 
 // this function manages printing of downloading progress, it prints the progress
-// every second and also update progress file every second
+// (or passes it to OnProgress) every ProgressInterval and also updates progress file
 func (d *Downloader) ManageProgressPrinter(stopChan chan struct{}) {
 
-	ticker := time.NewTicker(1000 * time.Millisecond)
+	ticker := time.NewTicker(d.progressInterval())
+
+	// speed is averaged over last speedWindow so it follows current
+	// conditions, first sample is resume position so resumed bytes don't count
+	startTime := time.Now()
+	samples := []speedSample{{at: startTime, bytes: atomic.LoadInt64(&d.Downloaded)}}
+	atomic.StoreInt64(&d.PassedMilliSc, 0)
 
 	go func() {
 		defer ticker.Stop()
@@ -226,35 +1272,44 @@ func (d *Downloader) ManageProgressPrinter(stopChan chan struct{}) {
 			case <-ticker.C:
 				// load downloaded byte count
 				current := atomic.LoadInt64(&d.Downloaded)
-				// load passed milliseconds
-				atomic.AddInt64(&d.PassedMilliSc, 1000)
-				passedSecs := float64(atomic.LoadInt64(&d.PassedMilliSc)) / 1000.0
-
-				if d.TotalSize > 0 {
-					var bps float64 = 0
-					// also remove d.ResumedAt which is loaded when downloading is resumed
-					if passedSecs > 0 {
-						bps = float64(current-d.ResumedAt) / passedSecs // speed is byte/s
-					}
+				// elapsed time is measured, ticks may arrive late
+				now := time.Now()
+				atomic.StoreInt64(&d.PassedMilliSc, now.Sub(startTime).Milliseconds())
 
-					var eta int64 = 0
-					if bps > 0 {
-						eta = int64(float64(d.TotalSize-current) / bps)
-
-					}
+				if prev := samples[len(samples)-1]; now.After(prev.at) {
+					d.speeds.add(max(float64(current-prev.bytes)/now.Sub(prev.at).Seconds(), 0))
+				}
+				samples = append(samples, speedSample{at: now, bytes: current})
+				for len(samples) > 2 && now.Sub(samples[1].at) >= speedWindow {
+					samples = samples[1:]
+				}
+				var bps float64 = 0
+				if elapsed := now.Sub(samples[0].at).Seconds(); elapsed > 0 {
+					bps = max(float64(current-samples[0].bytes)/elapsed, 0) // speed is byte/s
+				}
 
-					PrintFormattedInfo(current, d.TotalSize, bps, eta)
+				var eta int64 = 0
+				if bps > 0 && d.TotalSize > 0 {
+					eta = int64(float64(d.TotalSize-current) / bps)
 
 				}
 
-				if d.ProgressFile != nil {
-					d.ProgressFile.Seek(0, 0)
-					d.ProgressFile.Truncate(0)
-					d.ProgressFile.WriteString(fmt.Sprintf("%d", current))
-					d.ProgressFile.Sync()
+				atomic.StoreUint64(&d.speedBits, math.Float64bits(bps))
+				atomic.StoreInt64(&d.eta, eta)
 
+				if d.OnProgress != nil {
+					d.OnProgress(current, d.TotalSize, bps, eta)
+				} else if d.display != nil {
+					d.display.update(current, d.TotalSize, bps, eta)
+				} else if !d.Quiet {
+					d.bar.paused = d.Paused()
+					d.bar.stalled = d.stalled()
+					d.bar.print(d.out(), current, d.TotalSize, bps, eta)
 				}
 
+				d.sendProgress(current, d.TotalSize, bps, eta)
+				d.writeProgress(current, true)
+
 			case <-stopChan:
 				return
 			}
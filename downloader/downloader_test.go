@@ -0,0 +1,735 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshURLContinuesFromExpiredURL(t *testing.T) {
+	data := testData(64 << 10)
+	files := newFileServer(t, data)
+
+	// old URL expired, request for it is refused as by S3 presigned URL
+	var ranges []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Request has expired", http.StatusForbidden)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		files.Config.Handler.ServeHTTP(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL+"/old")
+	var refreshed int32
+	d.RefreshURL = func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&refreshed, 1)
+		return srv.URL + "/new", nil
+	}
+	writePartial(t, d, data, 20000)
+
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed != 1 {
+		t.Errorf("RefreshURL called %d times, want 1", refreshed)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=20000-" {
+		t.Errorf("fresh URL got Range %q, want [bytes=20000-]", ranges)
+	}
+	if d.Url != srv.URL+"/new" {
+		t.Errorf("Url is %s, want fresh URL", d.Url)
+	}
+	checkFile(t, d.FilePath, data)
+}
+
+func TestExpiredURLWithoutRefresh(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Request has expired", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	err := d.Download()
+	var status *BadStatusError
+	if !errors.As(err, &status) || status.Code != http.StatusForbidden {
+		t.Fatalf("got %v, want 403 BadStatusError", err)
+	}
+}
+
+func TestRefreshURLError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	errNoURL := errors.New("no fresh URL")
+	d.RefreshURL = func(ctx context.Context) (string, error) { return "", errNoURL }
+	if err := d.Download(); !errors.Is(err, errNoURL) {
+		t.Fatalf("got %v, want error of RefreshURL", err)
+	}
+}
+
+func TestMessagesToStderrKeepsStdoutClean(t *testing.T) {
+	data := testData(32 << 10)
+	srv := newFileServer(t, data)
+
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout, origStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	defer func() { os.Stdout, os.Stderr = origStdout, origStderr }()
+
+	d := newTestDownloader(t, srv.URL)
+	d.Quiet = false
+	d.MessagesToStderr = true
+	err = d.Download()
+	os.Stdout, os.Stderr = origStdout, origStderr
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+
+	if out, _ := os.ReadFile(stdout.Name()); len(out) > 0 {
+		t.Fatalf("stdout got %q, want nothing", out)
+	}
+	if msgs, _ := os.ReadFile(stderr.Name()); !bytes.Contains(msgs, []byte("Download completed")) {
+		t.Fatalf("stderr got %q, want download messages", msgs)
+	}
+}
+
+func TestCreatesMissingParentDirectories(t *testing.T) {
+	data := testData(16 << 10)
+	srv := newFileServer(t, data)
+	dir := t.TempDir()
+
+	d := NewDownloader(srv.URL, filepath.Join(dir, "downloads", "2024", "file.iso"), true)
+	d.Quiet = true
+	d.ProgressPath = filepath.Join(dir, "state", "nested", "file.progress")
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if info, err := os.Stat(filepath.Join(dir, "state", "nested")); err != nil || !info.IsDir() {
+		t.Fatalf("directory of progress file wasn't created: %v", err)
+	}
+}
+
+func TestPlainFileNameInWorkingDirectory(t *testing.T) {
+	data := testData(16 << 10)
+	srv := newFileServer(t, data)
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	d := NewDownloader(srv.URL, "file.bin", true)
+	d.Quiet = true
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(dir, "file.bin"), data)
+}
+
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("MEDOW_TEST_DIR", "/data")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", home},
+		{"~/sub", filepath.Join(home, "sub")},
+		{"~/sub/file.bin", filepath.Join(home, "sub", "file.bin")},
+		{"dir/~/file.bin", "dir/~/file.bin"},
+		{"~user/file.bin", "~user/file.bin"},
+		{"$MEDOW_TEST_DIR/file.bin", "/data/file.bin"},
+		{"${MEDOW_TEST_DIR}/file.bin", "/data/file.bin"},
+		{"$MEDOW_TEST_UNDEFINED/file.bin", "/file.bin"},
+		{"file.bin", "file.bin"},
+	}
+	for _, tt := range tests {
+		if got := expandPath(tt.path); got != tt.want {
+			t.Errorf("expandPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadIntoHomeDirectory(t *testing.T) {
+	data := testData(16 << 10)
+	srv := newFileServer(t, data)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	d := NewDownloader(srv.URL, "~/sub/file.bin", true)
+	d.Quiet = true
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(home, "sub", "file.bin"), data)
+}
+
+func TestResumeChecksPartialFileSize(t *testing.T) {
+	data := testData(64 << 10)
+	tests := []struct {
+		name      string
+		written   int // bytes in partial file, -1 for no file
+		recorded  int // bytes in progress file
+		prealloc  bool
+		wantRange string
+	}{
+		{"matching", 20000, 20000, false, "bytes=20000-"},
+		{"file shorter than progress", 10000, 20000, false, "bytes=10000-"},
+		{"file longer than progress", 30000, 20000, false, "bytes=30000-"},
+		{"preallocated file", len(data), 20000, true, "bytes=20000-"},
+		{"file missing", -1, 20000, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ranges := newRangeRecorder(t, data)
+			d := newTestDownloader(t, srv.URL)
+			d.Preallocate = tt.prealloc
+			writePartial(t, d, data, tt.recorded)
+			if tt.written < 0 {
+				os.Remove(d.FilePath + partSuffix)
+			} else if err := os.WriteFile(d.FilePath+partSuffix, data[:tt.written], 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.Download(); err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, d.FilePath, data)
+			if got := ranges(); len(got) != 1 || got[0] != tt.wantRange {
+				t.Fatalf("server got Range %q, want [%q]", got, tt.wantRange)
+			}
+		})
+	}
+}
+
+// run Pause, Resume, ProgressChan, CurrentSpeed and Stats from other
+// goroutines while download runs, go test -race reports unsynchronized state
+func TestControlDuringDownload(t *testing.T) {
+	data := testData(256 << 10)
+	for _, parallel := range []bool{false, true} {
+		var srv *httptest.Server
+		if parallel {
+			srv = newFileServer(t, data)
+		} else {
+			srv = newTrickleServer(t, data, 4096, 2*time.Millisecond)
+		}
+		d := newTestDownloader(t, srv.URL)
+		d.ProgressInterval = 5 * time.Millisecond
+		d.PersistEveryBytes = 16 << 10
+		if parallel {
+			d.SpeedLimit = 1 << 20
+		}
+		progress := d.ProgressChan()
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					d.Resume()
+					return
+				case <-time.After(3 * time.Millisecond):
+				}
+				d.Pause()
+				time.Sleep(time.Millisecond)
+				d.Resume()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range progress {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				d.CurrentSpeed()
+				d.Stats()
+				d.Paused()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		var err error
+		if parallel {
+			err = d.DownloadParallel(4)
+		} else {
+			err = d.Download()
+		}
+		close(done)
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFile(t, d.FilePath, data)
+		if _, err := os.Stat(d.ProgressPath); !os.IsNotExist(err) {
+			t.Fatalf("progress file left after success: %v", err)
+		}
+	}
+}
+
+// fail when more goroutines than base keep running, client connections
+// need a moment to notice that server closed them
+func checkGoroutines(t *testing.T, base int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left running, %d before downloads:\n%s",
+				runtime.NumGoroutine(), base, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNoGoroutineOutlivesDownload(t *testing.T) {
+	base := runtime.NumGoroutine()
+	data := testData(64 << 10)
+	files := newFileServer(t, data)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/broken":
+			// connection is cut in the middle of body
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:1000])
+			panic(http.ErrAbortHandler)
+		case "/slow":
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:1000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			files.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+
+	run := func(path string, setup func(d *Downloader), download func(d *Downloader) error) error {
+		d := newTestDownloader(t, srv.URL+path)
+		d.ProgressInterval = 5 * time.Millisecond
+		d.MaxRetries = 1
+		if setup != nil {
+			setup(d)
+		}
+		d.ProgressChan()
+		return download(d)
+	}
+	single := func(d *Downloader) error { return d.Download() }
+	if err := run("/file.bin", nil, single); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("/file.bin", nil, func(d *Downloader) error { return d.DownloadParallel(4) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("/missing", nil, single); err == nil {
+		t.Fatal("download of missing file succeeded")
+	}
+	if err := run("/broken", nil, single); err == nil {
+		t.Fatal("download of broken body succeeded")
+	}
+	if err := run("/slow", func(d *Downloader) { d.Timeout = 50 * time.Millisecond }, single); err == nil {
+		t.Fatal("stalled download succeeded")
+	}
+	err := run("/slow", nil, func(d *Downloader) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		return d.DownloadContext(ctx)
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want cancelled download", err)
+	}
+
+	srv.Close()
+	files.Close()
+	checkGoroutines(t, base)
+}
+
+// server announcing whole data in Content-Length but cutting connection
+// after first cut bytes of first response body, later requests get the rest
+func newShortBodyServer(t *testing.T, data []byte, cut int) *httptest.Server {
+	t.Helper()
+	return newChangingFileServer(t, data, data, cut)
+}
+
+func TestShortReadIsDetected(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newShortBodyServer(t, data, 10000)
+
+	d := newTestDownloader(t, srv.URL)
+	d.MaxRetries = 0
+	err := d.Download()
+	var short *ShortReadError
+	if !errors.As(err, &short) || short.Expected != int64(len(data)) || short.Got != 10000 {
+		t.Fatalf("got %v, want ShortReadError of 10000 of %d bytes", err, len(data))
+	}
+	if !errors.Is(err, ErrShortRead) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("%v doesn't match ErrShortRead and io.ErrUnexpectedEOF", err)
+	}
+	// short file is not passed off as complete
+	if _, err := os.Stat(d.FilePath); !os.IsNotExist(err) {
+		t.Fatalf("output file exists: %v", err)
+	}
+	checkFile(t, d.FilePath+partSuffix, data[:10000])
+}
+
+func TestShortReadIsResumed(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newShortBodyServer(t, data, 10000)
+
+	d := newTestDownloader(t, srv.URL)
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if s := d.Stats(); s.Retries != 1 || s.SessionBytes != int64(len(data)) {
+		t.Fatalf("got %d retries and %d received bytes, want 1 retry and no byte twice", s.Retries, s.SessionBytes)
+	}
+}
+
+func TestUnknownSizeIsNotShortRead(t *testing.T) {
+	data := testData(64 << 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush() // chunked response without Content-Length
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+}
+
+func TestProgressFileRemovedAfterSuccess(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newFileServer(t, data)
+	for _, connections := range []int{1, 4} {
+		d := newTestDownloader(t, srv.URL)
+		if err := d.DownloadParallel(connections); err != nil {
+			t.Fatal(err)
+		}
+		checkFile(t, d.FilePath, data)
+		for _, path := range []string{d.ProgressPath, d.FilePath + partSuffix, d.FilePath + partSuffix + ".segments"} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%d connections: %s left after success: %v", connections, filepath.Base(path), err)
+			}
+		}
+	}
+}
+
+func TestProgressFileKeptAfterFailure(t *testing.T) {
+	data := testData(64 << 10)
+
+	// interrupted transfer records where it stopped
+	srv := newShortBodyServer(t, data, 10000)
+	d := newTestDownloader(t, srv.URL)
+	d.MaxRetries = 0
+	if err := d.Download(); err == nil {
+		t.Fatal("short body was accepted")
+	}
+	state, err := LoadProgress(d.ProgressPath)
+	if err != nil || state.Downloaded != 10000 {
+		t.Fatalf("progress file records %d bytes (%v), want 10000", state.Downloaded, err)
+	}
+
+	// data failing verification are kept for investigation by default, but
+	// not their progress, resume at end of file would only get 416
+	srv = newFileServer(t, data)
+	d = newTestDownloader(t, srv.URL)
+	d.ExpectedChecksum = "sha256:" + sha256Hex([]byte("other data"))
+	if err := d.Download(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := os.Stat(d.ProgressPath); !os.IsNotExist(err) {
+		t.Fatalf("progress file was kept after failed verification: %v", err)
+	}
+	checkFile(t, d.FilePath+partSuffix, data)
+	if _, err := os.Stat(d.FilePath); !os.IsNotExist(err) {
+		t.Fatalf("unverified file was moved to output: %v", err)
+	}
+
+	// next run downloads file again from start
+	d = NewDownloader(srv.URL, d.FilePath, true)
+	d.Quiet = true
+	d.ExpectedChecksum = "sha256:" + sha256Hex(data)
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	if d.ResumedAt != 0 {
+		t.Errorf("download resumed at %d, want fresh one", d.ResumedAt)
+	}
+	checkFile(t, d.FilePath, data)
+}
+
+// counts GET requests reaching handler
+func countGets(handler http.Handler, gets *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(gets, 1)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestRestartIfChangedOnVerifyFailure(t *testing.T) {
+	old := testData(64 << 10)
+	current := testData(80 << 10)
+
+	// resume finding other total size downloads new file from start
+	srv := newChangingFileServer(t, old, current, 10000)
+	d := newTestDownloader(t, srv.URL)
+	d.OnVerifyFailure = RestartIfChangedOnVerifyFailure
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, current)
+
+	// full content for If-Range means new file, corruption found in it is
+	// not taken for transient one
+	var gets int32
+	srv = httptest.NewServer(countGets(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(current))
+	}), &gets))
+	t.Cleanup(srv.Close)
+	d = newTestDownloader(t, srv.URL)
+	d.OnVerifyFailure = RestartIfChangedOnVerifyFailure
+	d.ExpectedChecksum = "sha256:" + sha256Hex(old)
+	writePartial(t, d, old, 10000)
+	if err := os.WriteFile(d.FilePath+partSuffix+".progress", []byte("downloaded=10000\nvalidator=\"v1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want ErrChecksumMismatch", err)
+	}
+	if gets != 2 {
+		t.Errorf("server got %d requests, want changed file to be downloaded again", gets)
+	}
+	checkFile(t, d.FilePath+partSuffix, current)
+	if _, err := os.Stat(d.ProgressPath); !os.IsNotExist(err) {
+		t.Errorf("progress file was kept after failed verification: %v", err)
+	}
+
+	// unchanged file failing verification keeps its data
+	gets = 0
+	srv = httptest.NewServer(countGets(newFileServer(t, current).Config.Handler, &gets))
+	t.Cleanup(srv.Close)
+	d = newTestDownloader(t, srv.URL)
+	d.OnVerifyFailure = RestartIfChangedOnVerifyFailure
+	d.ExpectedChecksum = "sha256:" + sha256Hex(old)
+	if err := d.Download(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want ErrChecksumMismatch", err)
+	}
+	if gets != 1 {
+		t.Errorf("server got %d requests, want transient corruption not to restart", gets)
+	}
+	checkFile(t, d.FilePath+partSuffix, current)
+	if _, err := os.Stat(d.ProgressPath); !os.IsNotExist(err) {
+		t.Errorf("progress file was kept after failed verification: %v", err)
+	}
+}
+
+// server whose first response is cut short and whose every later response
+// serves other version of file, like CDN node that got new upload meanwhile
+func newChangingFileServer(t *testing.T, old, current []byte, cut int) *httptest.Server {
+	t.Helper()
+	files := newFileServer(t, current)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || atomic.AddInt32(&requests, 1) > 1 {
+			files.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nAccept-Ranges: bytes\r\n\r\n", len(old))
+		buf.Write(old[:cut])
+		buf.Flush()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSizeChangeAbortsResume(t *testing.T) {
+	old := testData(64 << 10)
+	current := testData(80 << 10)
+
+	// retry of the same download
+	srv := newChangingFileServer(t, old, current, 10000)
+	d := newTestDownloader(t, srv.URL)
+	d.MaxRetries = 3
+	err := d.Download()
+	var changed *SizeChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("got %v, want SizeChangedError", err)
+	}
+	if changed.Expected != int64(len(old)) || changed.Got != int64(len(current)) {
+		t.Errorf("size changed from %d to %d, want %d to %d", changed.Expected, changed.Got, len(old), len(current))
+	}
+	if _, err := os.Stat(d.FilePath); !os.IsNotExist(err) {
+		t.Errorf("mixed file was moved to output: %v", err)
+	}
+
+	// resume of earlier run whose progress file recorded other total
+	d = newTestDownloader(t, newFileServer(t, current).URL)
+	writePartial(t, d, old, 10000)
+	progress := fmt.Sprintf("downloaded=10000\ntotal=%d\n", len(old))
+	if err := os.WriteFile(d.FilePath+partSuffix+".progress", []byte(progress), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(); !errors.As(err, &changed) {
+		t.Fatalf("got %v, want SizeChangedError", err)
+	}
+	if _, err := os.Stat(d.FilePath); !os.IsNotExist(err) {
+		t.Errorf("mixed file was moved to output: %v", err)
+	}
+}
+
+func TestProgressFileHidesURLSecrets(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newShortBodyServer(t, data, 10000)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	d := newTestDownloader(t, "http://user:hunter2@"+host+"/file.bin?X-Amz-Signature=deadbeef&token=s3cr3t#frag")
+	d.MaxRetries = 0
+	if err := d.Download(); err == nil {
+		t.Fatal("short body was accepted")
+	}
+
+	raw, err := os.ReadFile(d.ProgressPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "deadbeef", "s3cr3t", "frag", "?"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("progress file contains %q:\n%s", secret, raw)
+		}
+	}
+	state, err := LoadProgress(d.ProgressPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://user:xxxxx@" + host + "/file.bin"; state.URL != want {
+		t.Errorf("url = %q, want %q", state.URL, want)
+	}
+}
+
+// server sending data in flushed pieces without Content-Length, so size is
+// known only once body ends, GET requests are counted in requests
+func newUnknownSizeServer(t *testing.T, data []byte, requests *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		for off := 0; off < len(data); off += 1000 {
+			if _, err := w.Write(data[off:min(off+1000, len(data))]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMaxBytes(t *testing.T) {
+	data := testData(64 << 10)
+	size := int64(len(data))
+
+	// announced size over limit fails before any data are written
+	for _, connections := range []int{1, 4} {
+		d := newTestDownloader(t, newFileServer(t, data).URL)
+		d.MaxBytes = 10000
+		err := d.DownloadParallel(connections)
+		if !errors.Is(err, ErrMaxBytesExceeded) {
+			t.Fatalf("%d connections: got %v, want ErrMaxBytesExceeded", connections, err)
+		}
+		if d.Downloaded != 0 {
+			t.Errorf("%d connections: %d bytes downloaded past announced size check", connections, d.Downloaded)
+		}
+		if info, err := os.Stat(d.FilePath + partSuffix); err == nil && info.Size() > 0 {
+			t.Errorf("%d connections: %d bytes written", connections, info.Size())
+		}
+	}
+
+	// unknown size trips limit in the middle of body, nothing past limit
+	// is written and the failure isn't retried
+	var requests int32
+	d := newTestDownloader(t, newUnknownSizeServer(t, data, &requests).URL)
+	d.MaxBytes = 10000
+	d.MaxRetries = 3
+	d.BufferSize = 1024 // every read brings at most this much
+	if err := d.Download(); !errors.Is(err, ErrMaxBytesExceeded) {
+		t.Fatalf("got %v, want ErrMaxBytesExceeded", err)
+	}
+	if d.Downloaded <= d.MaxBytes-d.BufferSize || d.Downloaded > d.MaxBytes {
+		t.Errorf("downloaded %d bytes with limit %d", d.Downloaded, d.MaxBytes)
+	}
+	info, err := os.Stat(d.FilePath + partSuffix)
+	if err != nil || info.Size() > d.MaxBytes {
+		t.Errorf("partial file has %v bytes (%v), limit is %d", info.Size(), err, d.MaxBytes)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, exceeded limit must not be retried", requests)
+	}
+	if _, err := os.Stat(d.FilePath); !os.IsNotExist(err) {
+		t.Errorf("file over limit was moved to output: %v", err)
+	}
+
+	// file of exactly MaxBytes is fine
+	for _, url := range []string{newFileServer(t, data).URL, newUnknownSizeServer(t, data, new(int32)).URL} {
+		d := newTestDownloader(t, url)
+		d.MaxBytes = size
+		if err := d.Download(); err != nil {
+			t.Fatal(err)
+		}
+		checkFile(t, d.FilePath, data)
+	}
+}
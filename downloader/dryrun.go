@@ -0,0 +1,253 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// describe what Download would do without writing anything: file name,
+// size, resume position, range support, speed limit and connections
+func (d *Downloader) DryRun() (string, error) {
+	return d.DryRunContext(context.Background())
+}
+
+// same as DryRun, request is bound to ctx
+func (d *Downloader) DryRunContext(ctx context.Context) (string, error) {
+	return d.DryRunParallelContext(ctx, 1)
+}
+
+// describe what DownloadParallel with given connections would do, report
+// shows how many connections it would really use and why fewer
+func (d *Downloader) DryRunParallelContext(ctx context.Context, connections int) (string, error) {
+	info, err := d.InspectContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// name from response is used only for this report, Download resolves
+	// it again from its own response
+	named := d.FilePath != ""
+	if !named {
+		if d.FilePath, err = sanitizeOutputPath(d.OutputDir, info.Filename); err != nil {
+			return "", err
+		}
+		defer func() { d.FilePath = "" }()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s\n", d.FilePath)
+	if info.Size >= 0 {
+		fmt.Fprintf(&b, "Size: %s (%d bytes)\n", FormatSize(info.Size, true), info.Size)
+	} else {
+		fmt.Fprintf(&b, "Size: unknown\n")
+	}
+	if info.ContentType != "" {
+		fmt.Fprintf(&b, "Type: %s\n", info.ContentType)
+	}
+	fmt.Fprintf(&b, "Partial downloads: %v\n", info.AcceptsRanges)
+
+	resumeAt := d.recordedProgress()
+	switch {
+	case resumeAt > 0 && info.AcceptsRanges:
+		fmt.Fprintf(&b, "Resume: from %s (%d bytes)\n", FormatSize(resumeAt, true), resumeAt)
+	case resumeAt > 0:
+		fmt.Fprintf(&b, "Resume: not possible, %d bytes would be downloaded again\n", resumeAt)
+	default:
+		fmt.Fprintf(&b, "Resume: no, download starts from zero\n")
+	}
+
+	if d.SpeedLimit > 0 {
+		fmt.Fprintf(&b, "Speed limit: %s\n", FormatSpeed(float64(d.SpeedLimit)))
+	} else if d.Limiter == nil {
+		fmt.Fprintf(&b, "Speed limit: unlimited\n")
+	}
+	if d.Limiter != nil {
+		fmt.Fprintf(&b, "Shared speed limit: %s\n", FormatSpeed(float64(d.Limiter.rate)))
+	}
+
+	if n, reason := d.plannedConnections(info, connections, named, resumeAt); reason != "" {
+		fmt.Fprintf(&b, "Connections: %d (%s)\n", n, reason)
+	} else {
+		fmt.Fprintf(&b, "Connections: %d\n", n)
+	}
+	return b.String(), nil
+}
+
+// connections DownloadParallelContext would use for inspected file, its
+// fallbacks to single stream are checked in the same order, reason tells
+// why requested connections are not used
+func (d *Downloader) plannedConnections(info *FileInfo, connections int, named bool, resumeAt int64) (int, string) {
+	if connections <= 1 {
+		return 1, ""
+	}
+	switch {
+	case d.Source != nil || d.RequestFunc != nil || isFTP(d.Url):
+		return 1, "source can't be split into segments"
+	case !named:
+		return 1, "file name comes from server response"
+	case d.streaming():
+		return 1, "output is a stream"
+	case resumeAt > 0:
+		return 1, "single connection download is resumed"
+	case d.conditional():
+		if _, ok := d.localFile(); ok {
+			return 1, "conditional download of existing file"
+		}
+	}
+	switch {
+	case !info.AcceptsRanges || info.Size <= 0:
+		return 1, "server doesn't support partial downloads"
+	case d.AutoParallel && info.Size <= d.parallelThreshold():
+		return 1, fmt.Sprintf("file is not bigger than %s", FormatSize(d.parallelThreshold(), true))
+	}
+	return connections, ""
+}
+
+// byte count stored in progress file Download would resume from, read
+// without creating or changing any file
+func (d *Downloader) recordedProgress() int64 {
+	if !d.UseProgressFile || d.streaming() {
+		return 0
+	}
+	path := d.ProgressPath
+	if path == "" || d.StagingDir != "" || path == d.FilePath+".progress" {
+		path = d.dataPath() + ".progress"
+	}
+	state, err := LoadProgress(path)
+	if err != nil {
+		return 0
+	}
+	return state.Downloaded
+}
+
+// what Queue would do with one URL
+type PlanAction string
+
+const (
+	PlanDownload  PlanAction = "download"  // file is downloaded from start
+	PlanResume    PlanAction = "resume"    // partial download continues
+	PlanOverwrite PlanAction = "overwrite" // existing complete file is replaced because of Overwrite
+	PlanExists    PlanAction = "exists"    // complete file is in the way, its download would fail
+	PlanConflict  PlanAction = "conflict"  // earlier URL of queue writes the same file
+	PlanError     PlanAction = "error"     // URL can't be inspected, its download would fail
+)
+
+// planned download of one URL of Queue
+type PlanEntry struct {
+	URL    string
+	Path   string // output file, empty when URL couldn't be inspected
+	Size   int64  // -1 when unknown
+	Resume int64  // bytes of partial download that would be kept
+	Action PlanAction
+	Err    error // reason of PlanError
+}
+
+// report what Download would do with urls without downloading or writing
+// anything, every URL is inspected with HEAD request for its size and name,
+// with ProbeMaxAge Download then reuses the results
+func (q *Queue) Plan(ctx context.Context, urls []string) []PlanEntry {
+	q.prepareProbes()
+	entries := make([]PlanEntry, len(urls))
+	written := make(map[string]bool)
+	for i, url := range urls {
+		entries[i] = q.planEntry(ctx, url)
+		if path := entries[i].Path; path != "" {
+			if written[path] {
+				entries[i].Action = PlanConflict
+			}
+			written[path] = true
+		}
+	}
+	if q.client != nil {
+		q.client.CloseIdleConnections()
+	}
+	return entries
+}
+
+func (q *Queue) planEntry(ctx context.Context, url string) PlanEntry {
+	entry := PlanEntry{URL: url, Size: -1, Action: PlanError}
+	cfg := q.Config
+	cfg.URL = url
+	cfg.FilePath = ""
+	d, err := NewDownloaderWithConfig(cfg)
+	if err != nil {
+		entry.Err = err
+		return entry
+	}
+	d.client = q.client
+	d.probes = q.probes
+	info, err := d.InspectContext(ctx)
+	q.client = d.client
+	if err != nil {
+		entry.Err = err
+		return entry
+	}
+	entry.Size = info.Size
+	if entry.Path, err = sanitizeOutputPath(d.OutputDir, info.Filename); err != nil {
+		entry.Err = err
+		return entry
+	}
+
+	d.FilePath = entry.Path
+	if resume := d.recordedProgress(); resume > 0 && info.AcceptsRanges {
+		entry.Action = PlanResume
+		entry.Resume = resume
+	} else if fi, err := os.Stat(entry.Path); err == nil && fi.Mode().IsRegular() {
+		entry.Action = PlanExists
+		if d.Overwrite {
+			entry.Action = PlanOverwrite
+		}
+	} else {
+		entry.Action = PlanDownload
+	}
+	return entry
+}
+
+// format plan as table with one line per URL followed by amount of data
+// the queue would download
+func FormatPlan(entries []PlanEntry) string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tSIZE\tFILE\tURL")
+
+	var remaining int64
+	files, unknown := 0, 0
+	for _, e := range entries {
+		size := "unknown"
+		if e.Size >= 0 {
+			size = FormatSize(e.Size, true)
+		}
+		file := e.Path
+		switch e.Action {
+		case PlanError:
+			file = "error: " + e.Err.Error()
+		case PlanResume:
+			size = FormatSize(e.Resume, true) + " of " + size
+		case PlanExists:
+			file += " (exists, use -force to overwrite it)"
+		case PlanConflict:
+			file += " (written by earlier URL)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Action, size, file, e.URL)
+
+		if e.Action == PlanDownload || e.Action == PlanResume || e.Action == PlanOverwrite {
+			files++
+			if e.Size < 0 {
+				unknown++
+			} else {
+				remaining += e.Size - e.Resume
+			}
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(&b, "\n%d of %d files to download, %s", files, len(entries), FormatSize(remaining, true))
+	if unknown > 0 {
+		fmt.Fprintf(&b, " and %d files of unknown size", unknown)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueuePlan(t *testing.T) {
+	data := testData(4096)
+	files := newFileServer(t, data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// GET for single byte is fallback of refused HEAD
+		if r.Method != http.MethodHead && r.Header.Get("Range") != "bytes=0-0" {
+			t.Errorf("plan sent %s request for %s", r.Method, r.Header.Get("Range"))
+		}
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+			return
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "done.bin"), data, 0644)
+	os.WriteFile(filepath.Join(dir, "half.bin.part"), data[:1000], 0644)
+	os.WriteFile(filepath.Join(dir, "half.bin.part.progress"), []byte("downloaded=1000\n"), 0644)
+
+	q := &Queue{Config: Config{OutputDir: dir, UseProgressFile: true, Quiet: true}}
+	urls := []string{
+		srv.URL + "/new.bin",
+		srv.URL + "/half.bin",
+		srv.URL + "/done.bin",
+		srv.URL + "/other/new.bin",
+		srv.URL + "/missing.bin",
+	}
+	entries := q.Plan(context.Background(), urls)
+
+	want := []struct {
+		action PlanAction
+		path   string
+		resume int64
+	}{
+		{PlanDownload, "new.bin", 0},
+		{PlanResume, "half.bin", 1000},
+		{PlanExists, "done.bin", 0},
+		{PlanConflict, "new.bin", 0},
+		{PlanError, "", 0},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		e := entries[i]
+		path := ""
+		if w.path != "" {
+			path = filepath.Join(dir, w.path)
+		}
+		if e.Action != w.action || e.Path != path || e.Resume != w.resume {
+			t.Errorf("%s: got %s %q resume %d, want %s %q resume %d", e.URL, e.Action, e.Path, e.Resume, w.action, path, w.resume)
+		}
+		if w.action != PlanError && e.Size != int64(len(data)) {
+			t.Errorf("%s: got size %d, want %d", e.URL, e.Size, len(data))
+		}
+	}
+	if entries[4].Err == nil {
+		t.Error("missing URL has no error")
+	}
+
+	// nothing is written by plan
+	names, _ := os.ReadDir(dir)
+	if len(names) != 3 {
+		t.Errorf("plan changed output directory, it has %d entries", len(names))
+	}
+
+	report := FormatPlan(entries)
+	if !strings.Contains(report, "2 of 5 files to download, 7.02 KiB") {
+		t.Errorf("unexpected summary in plan:\n%s", report)
+	}
+}
+
+func TestQueuePlanOverwrite(t *testing.T) {
+	data := testData(100)
+	srv := newFileServer(t, data)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "done.bin"), data, 0644)
+
+	q := &Queue{Config: Config{OutputDir: dir, Overwrite: true, Quiet: true}}
+	entries := q.Plan(context.Background(), []string{srv.URL + "/done.bin"})
+	if entries[0].Action != PlanOverwrite {
+		t.Errorf("got %s, want %s", entries[0].Action, PlanOverwrite)
+	}
+}
+
+func TestDryRunConnections(t *testing.T) {
+	data := testData(64 << 10)
+	ranged := newFileServer(t, data)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer plain.Close()
+
+	tests := []struct {
+		name  string
+		url   string
+		setup func(d *Downloader)
+		conns int
+		want  string
+	}{
+		{"parallel", ranged.URL, nil, 4, "Connections: 4\n"},
+		{"single requested", ranged.URL, nil, 1, "Connections: 1\n"},
+		{"no range support", plain.URL, nil, 4, "Connections: 1 (server doesn't support partial downloads)\n"},
+		{"below threshold", ranged.URL, func(d *Downloader) { d.AutoParallel = true }, 4,
+			"Connections: 1 (file is not bigger than 50.00 MiB)\n"},
+		{"above threshold", ranged.URL, func(d *Downloader) {
+			d.AutoParallel = true
+			d.ParallelThreshold = 1024
+		}, 4, "Connections: 4\n"},
+		{"resumed single stream", ranged.URL, func(d *Downloader) { writePartial(t, d, data, 1000) }, 4,
+			"Connections: 1 (single connection download is resumed)\n"},
+		{"name from response", ranged.URL, func(d *Downloader) {
+			d.OutputDir = filepath.Dir(d.FilePath)
+			d.FilePath = ""
+		}, 4, "Connections: 1 (file name comes from server response)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDownloader(t, tt.url)
+			if tt.setup != nil {
+				tt.setup(d)
+			}
+			report, err := d.DryRunParallelContext(context.Background(), tt.conns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(report, tt.want) {
+				t.Fatalf("report ends with wrong connections line:\n%s", report)
+			}
+		})
+	}
+}
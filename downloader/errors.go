@@ -0,0 +1,227 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// error values for errors.Is, typed errors below match them so callers can
+// decide what to do without looking at details
+var (
+	// server answered resumed request with whole file
+	ErrRangeNotSupported = errors.New("server does not support partial downloads")
+	// matched by *BadStatusError
+	ErrBadStatus = errors.New("bad HTTP status")
+	// matched by *ChecksumMismatchError
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// matched by *SizeMismatchError
+	ErrSizeMismatch = errors.New("size mismatch")
+	// complete file is in the way of fresh download and Overwrite is not set
+	ErrFileExists = errors.New("file already exists")
+	// matched by *ShortReadError
+	ErrShortRead = errors.New("body length differs from announced size")
+	// signed Url presumably expired since progress was recorded
+	ErrURLExpired = errors.New("signed URL has probably expired")
+	// whole download took longer than MaxDuration
+	ErrMaxDurationExceeded = errors.New("maximum download duration exceeded")
+	// matched by *StallError
+	ErrStalled = errors.New("download stalled")
+	// file is bigger than MaxBytes allows
+	ErrMaxBytesExceeded = errors.New("maximum file size exceeded")
+	// matched by *OpenFilesError
+	ErrTooManyOpenFiles = errors.New("too many open files needed")
+	// concurrent jobs of Queue would write the same output file
+	ErrOutputConflict = errors.New("output file is written by earlier URL of queue")
+)
+
+// returned when too many bytes had to be downloaded again, which signals
+// that server keeps failing and resume can't make real progress
+type RedownloadLimitError struct {
+	Redownloaded int64
+	TotalSize    int64
+}
+
+func (e *RedownloadLimitError) Error() string {
+	return fmt.Sprintf("re-downloaded %d bytes of %d byte file, giving up on broken server",
+		e.Redownloaded, e.TotalSize)
+}
+
+// returned when detached signature of checksum file doesn't verify, the
+// checksums it contains must not be trusted
+type SignatureError struct {
+	File   string
+	Output string // output of gpg explaining the failure
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("bad signature of %s: %s", e.File, e.Output)
+}
+
+// returned when downloaded data don't match expected checksum
+type ChecksumMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool { return target == ErrChecksumMismatch }
+
+// returned when size of downloaded data differs from ExpectedSize
+type SizeMismatchError struct {
+	Expected int64
+	Got      int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("size mismatch: expected %d bytes, got %d", e.Expected, e.Got)
+}
+
+func (e *SizeMismatchError) Is(target error) bool { return target == ErrSizeMismatch }
+
+// returned when resumed response announces other total size than earlier
+// response or progress file, data of two versions of file would be mixed
+type SizeChangedError struct {
+	Expected int64
+	Got      int64
+}
+
+func (e *SizeChangedError) Error() string {
+	return fmt.Sprintf("file size changed from %d to %d bytes during download, it has to be downloaded again from start",
+		e.Expected, e.Got)
+}
+
+// returned when response body ended at other size than server announced
+// in Content-Length or Content-Range, shorter body also matches
+// io.ErrUnexpectedEOF so download is retried from where it ended
+type ShortReadError struct {
+	Expected int64
+	Got      int64
+}
+
+func (e *ShortReadError) Error() string {
+	if e.Got > e.Expected {
+		return fmt.Sprintf("server sent %d bytes, %d more than announced", e.Got, e.Got-e.Expected)
+	}
+	return fmt.Sprintf("download ended at %d of %d bytes", e.Got, e.Expected)
+}
+
+func (e *ShortReadError) Is(target error) bool {
+	return target == ErrShortRead || (target == io.ErrUnexpectedEOF && e.Got < e.Expected)
+}
+
+// returned when server answers with unexpected HTTP status
+type BadStatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration // delay asked for by Retry-After of 429 or 503 response, 0 when not sent
+}
+
+func (e *BadStatusError) Error() string {
+	return fmt.Sprintf("bad HTTP status %s", e.Status)
+}
+
+func (e *BadStatusError) Is(target error) bool { return target == ErrBadStatus }
+
+// returned when no data arrived for Downloader.Timeout, it is net.Error
+// with Timeout() true so it is retried like other network timeouts
+type IdleTimeoutError struct {
+	Idle time.Duration
+}
+
+func (e *IdleTimeoutError) Error() string {
+	return fmt.Sprintf("no data received for %s", e.Idle)
+}
+
+func (e *IdleTimeoutError) Timeout() bool   { return true }
+func (e *IdleTimeoutError) Temporary() bool { return true }
+
+// returned when window of Downloader.StallTimeout brought less than
+// StallSpeed on average once transfer started, it is retried like
+// IdleTimeoutError and matches ErrStalled
+type StallError struct {
+	Stalled  time.Duration
+	Received int64 // bytes received in window
+	MinSpeed int64 // byte/s expected at least
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("download stalled, %d bytes received in %s, slower than %s",
+		e.Received, e.Stalled, FormatSpeed(float64(e.MinSpeed)))
+}
+
+func (e *StallError) Is(target error) bool { return target == ErrStalled }
+func (e *StallError) Timeout() bool        { return true }
+func (e *StallError) Temporary() bool      { return true }
+
+// returned when server redirects more times than MaxRedirects allows,
+// Location is where the refused redirect pointed to
+type RedirectLimitError struct {
+	Limit    int
+	Location string
+}
+
+func (e *RedirectLimitError) Error() string {
+	if e.Limit == 0 {
+		return fmt.Sprintf("redirects are disabled, server redirected to %s", e.Location)
+	}
+	return fmt.Sprintf("stopped after %d redirects, next one pointed to %s", e.Limit, e.Location)
+}
+
+// returned when redirect leads from https to http (unless
+// AllowInsecureRedirect) or to scheme other than http and https
+type InsecureRedirectError struct {
+	From     string
+	Location string
+}
+
+func (e *InsecureRedirectError) Error() string {
+	return fmt.Sprintf("refused redirect from %s to %s", e.From, e.Location)
+}
+
+// returned when filesystem doesn't have enough free space for download
+type InsufficientSpaceError struct {
+	Dir       string
+	Needed    int64
+	Available int64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough free space in %s: need %s, available %s",
+		e.Dir, FormatSize(e.Needed, true), FormatSize(e.Available, true))
+}
+
+// returned by Queue before anything is downloaded when its concurrent
+// downloads would need more open files than process limit allows
+type OpenFilesError struct {
+	Need        int64
+	Limit       int64
+	Jobs        int
+	Connections int
+}
+
+func (e *OpenFilesError) Error() string {
+	return fmt.Sprintf("%d jobs with %d connections need about %d open files but limit is %d, lower number of jobs or connections or raise the limit (ulimit -n)",
+		e.Jobs, e.Connections, e.Need, e.Limit)
+}
+
+func (e *OpenFilesError) Is(target error) bool { return target == ErrTooManyOpenFiles }
+
+// returned together with download error when progress file couldn't be
+// written, position reached by download is then not recorded for resume
+type ProgressWriteError struct {
+	Path string
+	Err  error
+}
+
+func (e *ProgressWriteError) Error() string {
+	return fmt.Sprintf("writing progress file %s: %v", e.Path, e.Err)
+}
+
+func (e *ProgressWriteError) Unwrap() error {
+	return e.Err
+}
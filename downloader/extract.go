@@ -0,0 +1,246 @@
+package downloader
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// download tar archive, gzip compressed or plain, and unpack it into destDir
+// while it arrives, nothing is written to FilePath and there's no progress
+// file because extraction can't continue in the middle of archive, entries
+// leaving destDir are refused and existing files are replaced only with
+// Overwrite
+func (d *Downloader) DownloadAndExtract(destDir string) error {
+	return d.DownloadAndExtractContext(context.Background(), destDir)
+}
+
+// same as DownloadAndExtract, cancelling ctx stops download
+func (d *Downloader) DownloadAndExtractContext(ctx context.Context, destDir string) error {
+	stream, err := d.OpenStreamContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	if err := d.extract(stream, destDir); err != nil {
+		return err
+	}
+
+	// rest of stream after end of archive carries failure of download or
+	// of its checksum verification
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return err
+	}
+	d.printf("Extracted to: %s\n", destDir)
+	return nil
+}
+
+// unpack tar entries of r into destDir, gzip is recognized by its magic
+// bytes, files and directories are created through os.Root so no entry can
+// reach outside of destDir
+func (d *Downloader) extract(r io.Reader, destDir string) error {
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	br := bufio.NewReader(r)
+	var archive io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("reading gzip stream: %w", err)
+		}
+		defer gz.Close()
+		archive = gz
+	}
+
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar archive: %w", err)
+		}
+		if err := d.extractEntry(tr, hdr, root, destDir); err != nil {
+			return fmt.Errorf("tar entry %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// write one tar entry, links must point inside destDir, device files and
+// other special entries are skipped, no entry is written through symlink
+// extracted earlier because symlink chain like "b -> ." and "a -> b/.."
+// would lead out of destDir even though every link looks local on its own
+func (d *Downloader) extractEntry(tr *tar.Reader, hdr *tar.Header, root *os.Root, destDir string) error {
+	path, err := sanitizeOutputPath(destDir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	name := filepath.Clean(filepath.FromSlash(hdr.Name))
+	if err := checkParents(root, name); err != nil {
+		return err
+	}
+	d.debugf("extracting %s", path)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return mkdirAll(root, name)
+
+	case tar.TypeReg:
+		if err := mkdirAll(root, filepath.Dir(name)); err != nil {
+			return err
+		}
+		flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if !d.Overwrite {
+			flag |= os.O_EXCL
+		}
+		if !d.FollowSymlinks {
+			if info, err := root.Lstat(name); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				return fmt.Errorf("refusing to write through symlink %s, enable FollowSymlinks to allow it", path)
+			}
+			flag |= oNoFollow
+		}
+		f, err := root.OpenFile(name, flag, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s: %w, use -force to overwrite it", path, ErrFileExists)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Chmod(hdr.FileInfo().Mode().Perm()); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+
+	case tar.TypeSymlink:
+		if !localLinkTarget(filepath.Dir(name), hdr.Linkname) {
+			return fmt.Errorf("refusing symlink to %q outside of %s", hdr.Linkname, destDir)
+		}
+		if err := mkdirAll(root, filepath.Dir(name)); err != nil {
+			return err
+		}
+		if _, err := root.Lstat(name); err == nil {
+			if !d.Overwrite {
+				return fmt.Errorf("%s: %w, use -force to overwrite it", path, ErrFileExists)
+			}
+			if err := root.Remove(name); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(hdr.Linkname, path)
+
+	case tar.TypeLink:
+		target, err := sanitizeOutputPath(destDir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		targetName := filepath.Clean(filepath.FromSlash(hdr.Linkname))
+		if err := checkParents(root, targetName); err != nil {
+			return err
+		}
+		// hard link of symlink would be symlink resolved from other directory
+		info, err := root.Lstat(targetName)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("refusing hard link to symlink %s", target)
+		}
+		if err := mkdirAll(root, filepath.Dir(name)); err != nil {
+			return err
+		}
+		return os.Link(target, path)
+	}
+
+	d.debugf("skipping %s of type %c", hdr.Name, hdr.Typeflag)
+	return nil
+}
+
+// refuse name whose existing parent directory inside root is symlink,
+// parents that don't exist yet are created later as plain directories
+func checkParents(root *os.Root, name string) error {
+	dir := filepath.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	parent := ""
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		parent = filepath.Join(parent, part)
+		info, err := root.Lstat(parent)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract through symlink %s", parent)
+		}
+	}
+	return nil
+}
+
+// reports whether symlink target, relative to directory of link, stays
+// inside root, target may only climb up with leading ".." and then descend,
+// ".." after a name could climb out of directory that name links to
+func localLinkTarget(dir, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	descending := false
+	for _, part := range strings.Split(filepath.ToSlash(target), "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if descending {
+				return false
+			}
+		default:
+			descending = true
+		}
+	}
+	return filepath.IsLocal(filepath.Join(dir, target))
+}
+
+// os.MkdirAll inside root, parents are checked by checkParents to be plain
+// directories
+func mkdirAll(root *os.Root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	if info, err := root.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if err := mkdirAll(root, filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := root.Mkdir(dir, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tar archive of given headers, regular files get body from contents
+func tarArchive(t *testing.T, entries []tar.Header, contents map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		hdr.Size = int64(len(contents[hdr.Name]))
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents[hdr.Name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extract archive served over HTTP into directory out inside fresh
+// temporary directory, files leaking out of out are left next to it
+func extractArchive(t *testing.T, archive []byte) (parent, out string, err error) {
+	t.Helper()
+	srv := newFileServer(t, archive)
+	d := newTestDownloader(t, srv.URL)
+	parent = t.TempDir()
+	out = filepath.Join(parent, "out")
+	return parent, out, d.DownloadAndExtract(out)
+}
+
+func TestExtract(t *testing.T) {
+	archive := tarArchive(t, []tar.Header{
+		{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "lib/libfoo.so.1.2", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "lib/libfoo.so.1", Typeflag: tar.TypeSymlink, Linkname: "libfoo.so.1.2"},
+		{Name: "lib/libfoo.so", Typeflag: tar.TypeSymlink, Linkname: "libfoo.so.1"},
+		{Name: "bin/tool", Typeflag: tar.TypeReg},
+		{Name: "bin/lib", Typeflag: tar.TypeSymlink, Linkname: "../lib"},
+		{Name: "bin/tool-copy", Typeflag: tar.TypeLink, Linkname: "bin/tool"},
+	}, map[string]string{"lib/libfoo.so.1.2": "library", "bin/tool": "tool"})
+
+	_, out, err := extractArchive(t, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(out, "lib", "libfoo.so"), []byte("library"))
+	checkFile(t, filepath.Join(out, "bin", "lib", "libfoo.so.1"), []byte("library"))
+	checkFile(t, filepath.Join(out, "bin", "tool-copy"), []byte("tool"))
+	info, err := os.Stat(filepath.Join(out, "lib", "libfoo.so.1.2"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("library mode %v (%v), want 0755", info.Mode().Perm(), err)
+	}
+}
+
+func TestExtractRefusesEscape(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		wantErr string
+	}{
+		{"symlink chain", []tar.Header{
+			{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b/.."},
+			{Name: "a/evil.txt", Typeflag: tar.TypeReg},
+		}, "refusing symlink"},
+		{"file through symlink", []tar.Header{
+			{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+			{Name: "a/evil.txt", Typeflag: tar.TypeReg},
+		}, "through symlink"},
+		{"symlink out", []tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "../"},
+			{Name: "a/evil.txt", Typeflag: tar.TypeReg},
+		}, "refusing symlink"},
+		{"absolute symlink", []tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+		}, "refusing symlink"},
+		{"hard link to symlink", []tar.Header{
+			{Name: "dir/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "up", Typeflag: tar.TypeLink, Linkname: "dir/up"},
+			{Name: "up/evil.txt", Typeflag: tar.TypeReg},
+		}, "hard link to symlink"},
+		{"hard link out", []tar.Header{
+			{Name: "a", Typeflag: tar.TypeLink, Linkname: "../evil.txt"},
+		}, "unsafe file name"},
+		{"parent name", []tar.Header{
+			{Name: "../evil.txt", Typeflag: tar.TypeReg},
+		}, "unsafe file name"},
+		{"absolute name", []tar.Header{
+			{Name: "/evil.txt", Typeflag: tar.TypeReg},
+		}, "unsafe file name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := tarArchive(t, tt.entries, map[string]string{"a/evil.txt": "evil", "up/evil.txt": "evil", "../evil.txt": "evil", "/evil.txt": "evil"})
+			parent, _, err := extractArchive(t, archive)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "out" {
+					t.Errorf("%s was written outside of output directory", e.Name())
+				}
+			}
+		})
+	}
+}
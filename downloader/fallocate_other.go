@@ -0,0 +1,14 @@
+//go:build !linux
+
+package downloader
+
+import (
+	"errors"
+	"os"
+)
+
+// reserving disk space is supported only on Linux, caller falls back to
+// setting file size
+func allocate(f *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// name used when neither server nor URL provide usable file name
+const defaultFilename = "index.html"
+
+// derive output file name from Content-Disposition header (filename* in
+// RFC 5987 encoding is decoded by mime package), then from last segment of
+// URL path, and finally fall back to index.html
+func resolveFilename(resp *http.Response, rawURL string) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := sanitizeFilename(params["filename"]); name != "" {
+			return name
+		}
+	}
+
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := sanitizeFilename(u.Path); name != "" {
+			return name
+		}
+	}
+	return defaultFilename
+}
+
+// make name received from server safe to use as file name in working
+// directory, only part after last path separator is kept (as browsers do)
+// and leading dots are removed so it can't point outside of it or create
+// hidden file, empty string means name is unusable
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.TrimLeft(strings.TrimSpace(name), ". ")
+}
+
+// join name received from server or archive with base directory, every
+// place taking such name (response, FTP path, dry run, plan, extraction)
+// goes through here, so names that skipped sanitizeFilename are caught too:
+// empty and absolute names, names leaving base via ".." and names with NUL
+// byte are refused, so is "." that would name base itself
+func sanitizeOutputPath(base, name string) (string, error) {
+	if strings.ContainsRune(name, 0) || !filepath.IsLocal(name) || filepath.Clean(name) == "." {
+		return "", fmt.Errorf("refusing unsafe file name %q from server", name)
+	}
+	return filepath.Join(base, name), nil
+}
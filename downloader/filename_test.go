@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"file.bin", "file.bin"},
+		{"dir/file.bin", "file.bin"},
+		{"../../etc/passwd", "passwd"},
+		{"..\\..\\windows\\evil.exe", "evil.exe"},
+		{"/absolute/file.bin", "file.bin"},
+		{".hidden", "hidden"},
+		{"...", ""},
+		{"..", ""},
+		{"", ""},
+		{"dir/", ""},
+		{"bad\x00name", "badname"},
+		{"line\nbreak\r.txt", "linebreak.txt"},
+		{"  spaced.txt  ", "spaced.txt"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeOutputPath(t *testing.T) {
+	base := filepath.Join("out", "dir")
+	ok := map[string]string{
+		"file.bin":     filepath.Join(base, "file.bin"),
+		"sub/file.bin": filepath.Join(base, "sub", "file.bin"),
+		"a/../b.bin":   filepath.Join(base, "b.bin"),
+	}
+	for name, want := range ok {
+		got, err := sanitizeOutputPath(base, name)
+		if err != nil || got != want {
+			t.Errorf("sanitizeOutputPath(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "../file.bin", "a/../../file.bin", "/etc/passwd", "bad\x00name", "sub/\x00"} {
+		if got, err := sanitizeOutputPath(base, name); err == nil {
+			t.Errorf("sanitizeOutputPath(%q) = %q, want error", name, got)
+		}
+	}
+}
+
+func TestServerFileNameStaysInOutputDir(t *testing.T) {
+	data := testData(4096)
+	tests := []struct {
+		disposition string
+		path        string
+		want        string
+	}{
+		{`attachment; filename="../../evil.txt"`, "/file.bin", "evil.txt"},
+		{`attachment; filename="..\\..\\evil.txt"`, "/file.bin", "evil.txt"},
+		{`attachment; filename="/etc/cron.d/evil"`, "/file.bin", "evil"},
+		{`attachment; filename=".."`, "/file.bin", "file.bin"},
+		{`attachment; filename*=UTF-8''..%2F..%2Fevil.txt`, "/file.bin", "evil.txt"},
+		{`attachment; filename*=UTF-8''bad%00name.txt`, "/file.bin", "badname.txt"},
+		{"", "/dir/..%2F..%2Fescape.bin", "escape.bin"},
+		{"", "/", defaultFilename},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.disposition != "" {
+				w.Header().Set("Content-Disposition", tt.disposition)
+			}
+			w.Write(data)
+		}))
+		dir := t.TempDir()
+		d, err := NewDownloaderWithConfig(Config{URL: srv.URL + tt.path, OutputDir: dir, Quiet: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.Download()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%q %s: %v", tt.disposition, tt.path, err)
+		}
+		if d.FilePath != filepath.Join(dir, tt.want) {
+			t.Errorf("%q %s: saved as %s, want %s in output directory", tt.disposition, tt.path, d.FilePath, tt.want)
+		}
+		checkFile(t, filepath.Join(dir, tt.want), data)
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("%q %s: %d entries in output directory, want 1", tt.disposition, tt.path, len(entries))
+		}
+	}
+}
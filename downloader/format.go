@@ -1,15 +1,34 @@
 package downloader
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
 
-// format eta from seconds to HH:MM:SS
+	"golang.org/x/term"
+)
+
+// format eta from seconds to HH:MM:SS, hours field widens past 99 hours
+// and negative eta (rounding at the very end of download) is shown as zero
 func FormatEta(secs int64) string {
+	secs = max(secs, 0)
 	h := secs / 3600
 	m := (secs % 3600) / 60
 	s := secs % 60
 	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
 }
 
+// same as FormatEta but eta of at least one day starts with days, e.g.
+// "2d 03:15:42", shorter eta keeps HH:MM:SS form
+func FormatEtaLong(secs int64) string {
+	const day = 24 * 3600
+	if secs < day {
+		return FormatEta(secs)
+	}
+	return fmt.Sprintf("%dd %s", secs/day, FormatEta(secs%day))
+}
+
 func FormatSpeed(bps float64) string {
 	if bps > 1_000_000 {
 		return fmt.Sprintf("%.2f MB/s", bps/1_000_000)
@@ -24,16 +43,80 @@ func FormatSpeed(bps float64) string {
 
 }
 
-func PrintFormattedInfo(current, totalSize int64, bps float64, eta int64) {
+// format byte count with unit scaled to its size, binary selects 1024 based
+// units (KiB, MiB, ...) instead of 1000 based ones (KB, MB, ...)
+func FormatSize(bytes int64, binary bool) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	base := 1000.0
+	if binary {
+		units = []string{"B", "KiB", "MiB", "GiB", "TiB"}
+		base = 1024.0
+	}
+
+	if float64(bytes) < base {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	size := float64(bytes)
+	i := 0
+	for size >= base && i < len(units)-1 {
+		size /= base
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", size, units[i])
+}
+
+// format single progress line without any control characters, unknown
+// total size (0) shows only downloaded bytes and speed
+func FormatInfo(current, totalSize int64, bps float64, eta int64) string {
+	if totalSize <= 0 {
+		return fmt.Sprintf("Progress: %s  DS: %s", FormatSize(current, true), FormatSpeed(bps))
+	}
 
 	percent := float64(current) / float64(totalSize) * 100
 
-	fmt.Printf("\rProgress: %.2f%% %d/%d MB  DS: %s ETA: %s",
+	return fmt.Sprintf("Progress: %.2f%% %s/%s  DS: %s ETA: %s",
 		percent,
-		current/1_000_000,
-		totalSize/1_000_000,
+		FormatSize(current, true),
+		FormatSize(totalSize, true),
 		FormatSpeed(bps),
-		FormatEta(eta),
+		FormatEtaLong(eta),
 	)
 
 }
+
+// stdout and stderr are checked once, redirected output stays redirected
+var (
+	stdoutTerminal = term.IsTerminal(int(os.Stdout.Fd()))
+	stderrTerminal = term.IsTerminal(int(os.Stderr.Fd()))
+	plainInfoMu    sync.Mutex
+	lastPlainInfo  time.Time
+)
+
+// reports whether f is terminal, progress is then redrawn in place
+func isTerminal(f *os.File) bool {
+	switch f {
+	case os.Stdout:
+		return stdoutTerminal
+	case os.Stderr:
+		return stderrTerminal
+	}
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// print progress line to stdout, terminal gets it updated in place, log
+// file or pipe gets plain line every aggregateInterval instead
+func PrintFormattedInfo(current, totalSize int64, bps float64, eta int64) {
+	if !isTerminal(os.Stdout) {
+		plainInfoMu.Lock()
+		defer plainInfoMu.Unlock()
+		if time.Since(lastPlainInfo) < aggregateInterval {
+			return
+		}
+		lastPlainInfo = time.Now()
+		fmt.Println(FormatInfo(current, totalSize, bps, eta))
+		return
+	}
+
+	fmt.Printf("\r%s", FormatInfo(current, totalSize, bps, eta))
+
+}
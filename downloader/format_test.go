@@ -0,0 +1,58 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatEta(t *testing.T) {
+	tests := []struct {
+		secs int64
+		want string
+	}{
+		{0, "00:00:00"},
+		{59, "00:00:59"},
+		{60, "00:01:00"},
+		{3599, "00:59:59"},
+		{3600, "01:00:00"},
+		{86399, "23:59:59"},
+		{100 * 3600, "100:00:00"},
+		{-1, "00:00:00"},
+		{-86400, "00:00:00"},
+	}
+	for _, tt := range tests {
+		if got := FormatEta(tt.secs); got != tt.want {
+			t.Errorf("FormatEta(%d) = %q, want %q", tt.secs, got, tt.want)
+		}
+	}
+}
+
+func TestFormatEtaLong(t *testing.T) {
+	tests := []struct {
+		secs int64
+		want string
+	}{
+		{0, "00:00:00"},
+		{3600, "01:00:00"},
+		{86399, "23:59:59"},
+		{86400, "1d 00:00:00"},
+		{86401, "1d 00:00:01"},
+		{2*86400 + 3*3600 + 15*60 + 42, "2d 03:15:42"},
+		{100 * 86400, "100d 00:00:00"},
+		{-5, "00:00:00"},
+	}
+	for _, tt := range tests {
+		if got := FormatEtaLong(tt.secs); got != tt.want {
+			t.Errorf("FormatEtaLong(%d) = %q, want %q", tt.secs, got, tt.want)
+		}
+	}
+}
+
+func TestFormatInfoShowsDays(t *testing.T) {
+	if got := FormatInfo(0, 1<<30, 1000, 86400+61); !strings.Contains(got, "1d 00:01:01") {
+		t.Errorf("progress line %q doesn't show ETA with days", got)
+	}
+	if got := FormatInfo(0, 1<<30, 1000, 86399); !strings.Contains(got, "ETA: 23:59:59") {
+		t.Errorf("progress line %q doesn't show ETA below one day as HH:MM:SS", got)
+	}
+}
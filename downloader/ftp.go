@@ -0,0 +1,239 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// source downloading ftp:// Url in passive mode, resume uses REST, login is
+// anonymous unless URL has user:pass@
+type ftpSource struct {
+	d *Downloader
+}
+
+// source used when Source is not set, picked by scheme of Url
+func (d *Downloader) source() Source {
+	if d.Source != nil {
+		return d.Source
+	}
+	if isFTP(d.Url) {
+		return &ftpSource{d: d}
+	}
+	return &httpSource{d: d}
+}
+
+func isFTP(rawURL string) bool {
+	return len(rawURL) >= 6 && strings.EqualFold(rawURL[:6], "ftp://")
+}
+
+func (s *ftpSource) Open(ctx context.Context, offset int64) (io.ReadCloser, int64, error) {
+	d := s.d
+	u, err := url.Parse(d.Url)
+	if err != nil {
+		return nil, 0, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	// path is relative to login directory (RFC 1738), "//" makes it absolute
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == "" || strings.HasSuffix(path, "/") {
+		return nil, 0, fmt.Errorf("ftp URL %s doesn't name a file", d.Url)
+	}
+
+	dialer := d.ftpDialer()
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	c := &ftpConn{Conn: textproto.NewConn(conn), ctrl: conn, host: u.Hostname(), dialer: dialer}
+
+	// cancelling ctx interrupts any blocked command
+	c.stopWatch = context.AfterFunc(ctx, func() { c.Close() })
+
+	user := u.User
+	if user == nil {
+		if login, password, ok := d.netrcAuth(u.Hostname()); ok {
+			user = url.UserPassword(login, password)
+		}
+	}
+	body, size, err := c.retrieve(ctx, user, path, offset)
+	if err != nil {
+		c.stopWatch()
+		c.Close()
+		return nil, 0, readResult(ctx, err)
+	}
+	d.debugf("ftp %s: size %d, resumed at %d", d.Url, size, offset)
+
+	if d.FilePath == "" && d.sink == nil {
+		name := sanitizeFilename(path)
+		if name == "" {
+			name = defaultFilename
+		}
+		if d.FilePath, err = sanitizeOutputPath(d.OutputDir, name); err != nil {
+			body.Close()
+			return nil, 0, err
+		}
+		d.debugf("resolved file name %s", d.FilePath)
+	}
+	return body, size, nil
+}
+
+// default connect timeout of FTP, the same as dialer of http.DefaultTransport
+const defaultFTPConnectTimeout = 30 * time.Second
+
+// dialer of control and data connections, ConnectTimeout limits only
+// connecting like for HTTP, idle Timeout is watched on data as they arrive
+func (d *Downloader) ftpDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: defaultFTPConnectTimeout, KeepAlive: 30 * time.Second}
+	if d.ConnectTimeout > 0 {
+		dialer.Timeout = d.ConnectTimeout
+	}
+	return dialer
+}
+
+// control connection of one FTP session
+type ftpConn struct {
+	*textproto.Conn
+	ctrl      net.Conn
+	host      string
+	dialer    *net.Dialer
+	stopWatch func() bool
+}
+
+// send command and read its reply, reply not starting with expect fails
+func (c *ftpConn) cmd(expect int, format string, args ...any) (int, string, error) {
+	if _, err := c.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.ReadResponse(expect)
+}
+
+// log in, open passive data connection and start transfer of path from
+// offset, size is number of bytes transfer provides or -1 when unknown
+func (c *ftpConn) retrieve(ctx context.Context, user *url.Userinfo, path string, offset int64) (io.ReadCloser, int64, error) {
+	if _, _, err := c.ReadResponse(2); err != nil {
+		return nil, 0, err
+	}
+
+	name, pass := "anonymous", "anonymous@"
+	if user != nil {
+		name = user.Username()
+		pass, _ = user.Password()
+	}
+	code, _, err := c.cmd(0, "USER %s", name)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch code {
+	case 230:
+	case 331, 332:
+		if _, _, err := c.cmd(2, "PASS %s", pass); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, &textproto.Error{Code: code, Msg: "login failed"}
+	}
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return nil, 0, err
+	}
+
+	// SIZE is an extension, server without it leaves size unknown
+	size := int64(-1)
+	if _, msg, err := c.cmd(213, "SIZE %s", path); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+			size = n
+		}
+	}
+
+	data, err := c.passive(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset > 0 {
+		if _, _, err := c.cmd(3, "REST %d", offset); err != nil {
+			data.Close()
+			return nil, 0, fmt.Errorf("%w: %w", ErrRangeNotSupported, err)
+		}
+	}
+	if _, _, err := c.cmd(1, "RETR %s", path); err != nil {
+		data.Close()
+		return nil, 0, err
+	}
+
+	if size >= 0 {
+		size = max(size-offset, 0)
+	}
+	stopData := context.AfterFunc(ctx, func() { data.Close() })
+	return &ftpBody{data: data, c: c, stopData: stopData}, size, nil
+}
+
+// open data connection announced by EPSV or, for older servers, PASV, it
+// always goes to host of control connection so server can't redirect it
+func (c *ftpConn) passive(ctx context.Context) (net.Conn, error) {
+	var port int
+	_, msg, err := c.cmd(229, "EPSV")
+	if err == nil {
+		// "Entering Extended Passive Mode (|||port|)"
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start+4 {
+			return nil, fmt.Errorf("ftp: malformed EPSV reply %q", msg)
+		}
+		port, err = strconv.Atoi(msg[start+4 : end])
+	} else {
+		_, msg, err = c.cmd(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		// "Entering Passive Mode (h1,h2,h3,h4,p1,p2)"
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("ftp: malformed PASV reply %q", msg)
+		}
+		fields := strings.Split(msg[start+1:end], ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("ftp: malformed PASV reply %q", msg)
+		}
+		p1, err1 := strconv.Atoi(fields[4])
+		p2, err2 := strconv.Atoi(fields[5])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("ftp: malformed PASV reply %q", msg)
+		}
+		port = p1<<8 | p2
+	}
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("ftp: invalid passive port in %q", msg)
+	}
+	return c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(port)))
+}
+
+// data of RETR transfer, closing it ends the session
+type ftpBody struct {
+	data     net.Conn
+	c        *ftpConn
+	stopData func() bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	return b.data.Read(p)
+}
+
+// close data connection, wait shortly for transfer status and log out
+func (b *ftpBody) Close() error {
+	b.stopData()
+	b.data.Close()
+	b.c.stopWatch()
+	b.c.ctrl.SetDeadline(time.Now().Add(5 * time.Second))
+	b.c.ReadResponse(2)
+	b.c.cmd(2, "QUIT")
+	return b.c.Close()
+}
@@ -0,0 +1,24 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFTPDialerUsesConnectTimeout(t *testing.T) {
+	tests := []struct {
+		timeout, connectTimeout, want time.Duration
+	}{
+		{0, 0, defaultFTPConnectTimeout},
+		{time.Second, 0, defaultFTPConnectTimeout}, // idle timeout doesn't limit connecting
+		{time.Hour, 2 * time.Second, 2 * time.Second},
+		{0, time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		d := &Downloader{Timeout: tt.timeout, ConnectTimeout: tt.connectTimeout}
+		if got := d.ftpDialer().Timeout; got != tt.want {
+			t.Errorf("Timeout %s, ConnectTimeout %s: dialer timeout %s, want %s",
+				tt.timeout, tt.connectTimeout, got, tt.want)
+		}
+	}
+}
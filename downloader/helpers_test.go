@@ -0,0 +1,181 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pseudo random content of size bytes, the same for every call
+func testData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+// server answering every path with data, Range requests are supported
+func newFileServer(t testing.TB, data []byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// quiet downloader writing file.bin into temporary directory, retries
+// wait only a moment
+func newTestDownloader(t *testing.T, url string) *Downloader {
+	t.Helper()
+	d := NewDownloader(url, filepath.Join(t.TempDir(), "file.bin"), true)
+	d.Quiet = true
+	d.RetryBaseDelay = time.Millisecond
+	return d
+}
+
+// fail unless file at path holds exactly want
+func checkFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s has %d bytes, want %d bytes of expected content", path, len(got), len(want))
+	}
+}
+
+// leave partial download of first n bytes of data with its progress file
+func writePartial(t *testing.T, d *Downloader, data []byte, n int) {
+	t.Helper()
+	if err := os.WriteFile(d.FilePath+partSuffix, data[:n], 0644); err != nil {
+		t.Fatal(err)
+	}
+	progress := []byte("downloaded=" + strconv.Itoa(n) + "\n")
+	if err := os.WriteFile(d.FilePath+partSuffix+".progress", progress, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hex SHA-256 digest of data, usable as ExpectedChecksum after "sha256:"
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// virtual time moved forward only by sleeping, rate limited reads then
+// take no real time and their timing is exact
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// clock for Downloader or RateLimiter reading and advancing c
+func (c *fakeClock) clock() clock {
+	return clock{nowFunc: c.Now, sleepFunc: c.Sleep}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// sink recording virtual time and running total of every write
+type timeline struct {
+	clock *fakeClock
+	start time.Time
+	at    []time.Duration // since start
+	total []int64         // bytes written including this write
+}
+
+func newTimeline(c *fakeClock) *timeline {
+	return &timeline{clock: c, start: c.Now()}
+}
+
+func (tl *timeline) Write(p []byte) (int, error) {
+	var total int64
+	if len(tl.total) > 0 {
+		total = tl.total[len(tl.total)-1]
+	}
+	tl.at = append(tl.at, tl.clock.Now().Sub(tl.start))
+	tl.total = append(tl.total, total+int64(len(p)))
+	return len(p), nil
+}
+
+// average rate in byte/s between first write at or after from and last write
+func (tl *timeline) rateSince(from time.Duration) float64 {
+	first := 0
+	for first < len(tl.at) && tl.at[first] < from {
+		first++
+	}
+	last := len(tl.at) - 1
+	if first >= last {
+		return 0
+	}
+	var before int64
+	if first > 0 {
+		before = tl.total[first-1]
+	}
+	// bytes of writes first..last-1 are paid by sleeping until write last
+	return float64(tl.total[last-1]-before) / (tl.at[last] - tl.at[first]).Seconds()
+}
+
+// file server recording Range header of every GET request, empty string
+// for request of whole file
+func newRangeRecorder(t *testing.T, data []byte) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(ranges)
+	}
+}
+
+// most bytes written within any window of given length
+func (tl *timeline) maxWindow(window time.Duration) int64 {
+	var most int64
+	j := 0
+	for i := range tl.at {
+		for j+1 < len(tl.at) && tl.at[j+1] < tl.at[i]+window {
+			j++
+		}
+		var before int64
+		if i > 0 {
+			before = tl.total[i-1]
+		}
+		most = max(most, tl.total[j]-before)
+	}
+	return most
+}
@@ -0,0 +1,56 @@
+package downloader
+
+import "sync"
+
+// number of speed samples kept for SpeedSamples
+const speedHistorySize = 120
+
+// ring of last instantaneous speeds, written by progress goroutine and read
+// by any goroutine, memory stays fixed however long download runs
+type speedHistory struct {
+	mu      sync.Mutex
+	samples [speedHistorySize]float64
+	next    int
+	count   int
+	peak    float64 // highest sample since reset, also ones dropped from ring
+}
+
+func (h *speedHistory) add(bps float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[h.next] = bps
+	h.next = (h.next + 1) % speedHistorySize
+	h.count = min(h.count+1, speedHistorySize)
+	h.peak = max(h.peak, bps)
+}
+
+func (h *speedHistory) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next, h.count = 0, 0
+	h.peak = 0
+}
+
+func (h *speedHistory) max() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.peak
+}
+
+// copy of samples, oldest first
+func (h *speedHistory) list() []float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]float64, 0, h.count)
+	for i := h.next - h.count; i < h.next; i++ {
+		out = append(out, h.samples[(i+speedHistorySize)%speedHistorySize])
+	}
+	return out
+}
+
+// speed in byte/s measured over each of last progress ticks (at most 120),
+// oldest first, unlike CurrentSpeed it is not averaged so it suits drawing
+// throughput graph, history starts anew with every Download call
+func (d *Downloader) SpeedSamples() []float64 {
+	return d.speeds.list()
+}
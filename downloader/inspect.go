@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// FileInfo describes remote file without downloading it
+type FileInfo struct {
+	Size          int64  // byte size, -1 when unknown
+	Filename      string // name file would be saved under when FilePath is empty
+	AcceptsRanges bool   // server supports resume and parallel download
+	ContentType   string
+	ETag          string
+	LastModified  time.Time // zero when server didn't send it
+}
+
+// query metadata of Url without downloading it
+func (d *Downloader) Inspect() (*FileInfo, error) {
+	return d.InspectContext(context.Background())
+}
+
+// same as Inspect, request is bound to ctx
+func (d *Downloader) InspectContext(ctx context.Context) (*FileInfo, error) {
+	httpClient, err := d.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.inspect(ctx, httpClient)
+	if err != nil {
+		return nil, d.tlsVersionError(err)
+	}
+	return info, nil
+}
+
+// HEAD is tried first and single byte range GET is used when HEAD is not
+// allowed, recent result of Queue inspecting the same URL is reused
+func (d *Downloader) inspect(ctx context.Context, httpClient *http.Client) (*FileInfo, error) {
+	if info, ok := d.probes.get(d.Url); ok {
+		d.debugf("reusing inspected size %d of %s", info.Size, d.Url)
+		return info, nil
+	}
+	info, err := d.probe(ctx, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	d.probes.put(d.Url, info)
+	return info, nil
+}
+
+func (d *Downloader) probe(ctx context.Context, httpClient *http.Client) (*FileInfo, error) {
+	req, err := d.newRequest(ctx, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.do(httpClient, req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			info := fileInfo(resp)
+			info.Size = resp.ContentLength
+			info.AcceptsRanges = resp.Header.Get("Accept-Ranges") == d.rangeUnit()
+			return info, nil
+		}
+	}
+
+	req, err = d.newRequest(ctx, "GET")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", d.rangeUnit()+"=0-0")
+	resp, err = d.do(httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		info := fileInfo(resp)
+		info.Size = -1
+		if _, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"), d.rangeUnit()); ok {
+			info.Size = total
+		}
+		info.AcceptsRanges = true
+		return info, nil
+	case http.StatusOK:
+		info := fileInfo(resp)
+		info.Size = resp.ContentLength
+		return info, nil
+	}
+	return nil, d.badStatus(resp)
+}
+
+// fields of FileInfo common to HEAD and GET responses
+func fileInfo(resp *http.Response) *FileInfo {
+	info := &FileInfo{
+		Filename:    resolveFilename(resp, resp.Request.URL.String()),
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info
+}
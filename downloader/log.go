@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// print message for user, nothing is printed in Quiet mode or when progress
+// is rendered by MultiProgress
+func (d *Downloader) printf(format string, args ...any) {
+	if d.Quiet || d.display != nil {
+		return
+	}
+	fmt.Fprintf(d.out(), format, args...)
+}
+
+// messages and progress go to stdout unless it carries downloaded data or
+// MessagesToStderr is set
+func (d *Downloader) out() *os.File {
+	if d.toStdout() || d.isPipe || d.MessagesToStderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// print diagnostic line to stderr in Verbose mode
+func (d *Downloader) debugf(format string, args ...any) {
+	if d.Verbose {
+		fmt.Fprintf(os.Stderr, "[medow] "+format+"\n", args...)
+	}
+}
+
+// logger for lifecycle events, discarding one when Logger is not set
+func (d *Downloader) logger() *slog.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return discardLogger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// perform request, Verbose mode logs request headers and response status
+func (d *Downloader) do(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	d.logger().Debug("request", "method", req.Method, "url", req.URL.String(), "range", req.Header.Get("Range"))
+	if d.Verbose {
+		d.debugf("%s %s", req.Method, req.URL)
+		keys := make([]string, 0, len(req.Header))
+		for key := range req.Header {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := req.Header.Get(key)
+			if key == "Authorization" {
+				value = "<redacted>"
+			}
+			d.debugf("  %s: %s", key, value)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		d.logger().Warn("request failed", "method", req.Method, "url", req.URL.String(), "error", err)
+		d.debugf("request failed: %v", err)
+		return nil, err
+	}
+	d.logger().Debug("response", "url", resp.Request.URL.String(), "status", resp.StatusCode, "content_length", resp.ContentLength)
+	d.debugf("response %s, Content-Length %d, Accept-Ranges %q", resp.Status, resp.ContentLength, resp.Header.Get("Accept-Ranges"))
+	return resp, nil
+}
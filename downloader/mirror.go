@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// switch Url to next usable mirror after failure of current one, mirror
+// must report the same size as already known, data of another file would
+// corrupt resumed download
+func (d *Downloader) switchMirror(ctx context.Context, err error) bool {
+	if !mirrorFailure(err) {
+		return false
+	}
+
+	for d.nextMirror < len(d.MirrorURLs) {
+		mirror := d.MirrorURLs[d.nextMirror]
+		d.nextMirror++
+
+		if checkErr := d.checkMirror(ctx, mirror); checkErr != nil {
+			d.debugf("skipping mirror %s: %v", mirror, checkErr)
+			continue
+		}
+		d.printf("\n%s failed: %v, switching to mirror %s\n", d.Url, err, mirror)
+		d.logger().Warn("switching to mirror", "from", d.Url, "to", mirror, "error", err)
+		d.Url = mirror
+		// validator of other server means nothing here, size was checked instead
+		d.ResumeValidator = ""
+		return true
+	}
+	return false
+}
+
+// failures which another server may not have, verification failures are
+// not among them because mirrors serve the same data
+func mirrorFailure(err error) bool {
+	var status *BadStatusError
+	return errors.As(err, &status) || isTransient(err)
+}
+
+// check that mirror is reachable and serves file of known size
+func (d *Downloader) checkMirror(ctx context.Context, mirror string) error {
+	httpClient, err := d.httpClient()
+	if err != nil {
+		return err
+	}
+	req, err := d.newRequestURL(ctx, "HEAD", mirror)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(httpClient, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return d.badStatus(resp)
+	}
+	if d.TotalSize > 0 && resp.ContentLength >= 0 && resp.ContentLength != d.TotalSize {
+		return &SizeMismatchError{Expected: d.TotalSize, Got: resp.ContentLength}
+	}
+	return nil
+}
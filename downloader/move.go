@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// os.Rename, replaced by tests to pretend src and dst are on different
+// filesystems
+var rename = os.Rename
+
+// move file from src to dst, rename is used when both are on the same
+// filesystem, otherwise data are copied next to dst and renamed into place
+// so dst never contains partially written file
+func moveFile(src, dst string) error {
+	err := rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	if err := copyFile(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copy content and permissions of src to new file dst and sync it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// make rename of files in dir fail with EXDEV like move to other filesystem,
+// rename of temporary copy next to destination still works, refused
+// renames are counted
+func crossDevice(t *testing.T, dir string) *int {
+	t.Helper()
+	t.Cleanup(func() { rename = os.Rename })
+	refused := new(int)
+	rename = func(oldpath, newpath string) error {
+		if filepath.Dir(oldpath) == dir {
+			*refused++
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	return refused
+}
+
+func TestMoveFileAcrossDevices(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	crossDevice(t, srcDir)
+	data := testData(100 << 10)
+	src := filepath.Join(srcDir, "file.bin.part")
+	dst := filepath.Join(dstDir, "file.bin")
+	if err := os.WriteFile(src, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, dst, data)
+	if info, err := os.Stat(dst); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("moved file mode %v (%v), want 0640", info.Mode().Perm(), err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source was not removed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dstDir); len(entries) != 1 {
+		t.Errorf("%d entries in destination, temporary copy was left behind", len(entries))
+	}
+}
+
+func TestDownloadMovesFromStagingDirAcrossDevices(t *testing.T) {
+	data := testData(64 << 10)
+	d := newTestDownloader(t, newFileServer(t, data).URL)
+	d.StagingDir = t.TempDir()
+	refused := crossDevice(t, d.StagingDir)
+
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if *refused != 1 {
+		t.Fatalf("%d renames out of staging directory, want 1 falling back to copy", *refused)
+	}
+	if entries, _ := os.ReadDir(d.StagingDir); len(entries) != 0 {
+		t.Errorf("%d files left in staging directory", len(entries))
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(d.FilePath)); len(entries) != 1 {
+		t.Errorf("%d entries next to downloaded file, want only the file", len(entries))
+	}
+}
@@ -0,0 +1,166 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MultiProgress coordinates progress output of several downloads running at
+// the same time, so their "\r" lines don't clobber each other. On a terminal
+// every tracked download gets its own stable line, otherwise one aggregate
+// line is printed periodically.
+type MultiProgress struct {
+	mu    sync.Mutex
+	out   *os.File
+	isTTY bool
+
+	lines []*progressLine
+	drawn int // number of lines drawn by last render, used for cursor movement
+
+	lastAggregate time.Time
+}
+
+// state of one tracked download
+type progressLine struct {
+	m     *MultiProgress
+	label string
+
+	current int64
+	total   int64
+	bps     float64
+	eta     int64
+	done    bool
+	failed  bool
+}
+
+// how often aggregate line is printed when output is not a terminal
+const aggregateInterval = 5 * time.Second
+
+// create new MultiProgress writing to out (usually os.Stdout)
+func NewMultiProgress(out *os.File) *MultiProgress {
+	return &MultiProgress{
+		out:   out,
+		isTTY: isTerminal(out),
+	}
+}
+
+// register downloader, its progress is then rendered by MultiProgress
+// instead of being printed directly
+func (m *MultiProgress) Track(d *Downloader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	line := &progressLine{m: m, label: trackLabel(d)}
+	m.lines = append(m.lines, line)
+	d.display = line
+}
+
+// name of file shown for download, file named by server response is
+// labelled by name in its URL
+func trackLabel(d *Downloader) string {
+	if d.FilePath != "" {
+		return filepath.Base(d.FilePath)
+	}
+	if u, err := url.Parse(d.Url); err == nil {
+		if name := sanitizeFilename(u.Path); name != "" {
+			return name
+		}
+	}
+	return d.Url
+}
+
+// store new progress values and redraw
+func (l *progressLine) update(current, total int64, bps float64, eta int64) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	l.current = current
+	l.total = total
+	l.bps = bps
+	l.eta = eta
+	l.m.render(false)
+}
+
+// mark download as finished, failed downloads keep their last progress
+func (l *progressLine) finish(err error) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	l.done = true
+	l.failed = err != nil
+	if err == nil && l.total > 0 {
+		l.current = l.total
+	}
+	l.m.render(true)
+}
+
+// draw all lines, caller must hold m.mu
+func (m *MultiProgress) render(force bool) {
+	if !m.isTTY {
+		m.renderAggregate(force)
+		return
+	}
+
+	// move cursor back to first line drawn previously
+	if m.drawn > 0 {
+		fmt.Fprintf(m.out, "\033[%dA", m.drawn)
+	}
+	for _, l := range m.lines {
+		fmt.Fprintf(m.out, "\r\033[2K%s %s\n", l.label, l.text())
+	}
+	m.drawn = len(m.lines)
+}
+
+// print one line summarizing all downloads, used when output is not a terminal
+func (m *MultiProgress) renderAggregate(force bool) {
+	if !force && time.Since(m.lastAggregate) < aggregateInterval {
+		return
+	}
+	m.lastAggregate = time.Now()
+
+	// total is known only when it is known for every download
+	var current, total int64
+	var bps float64
+	active := 0
+	unknown := false
+	for _, l := range m.lines {
+		current += l.current
+		total += l.total
+		unknown = unknown || l.total <= 0
+		if !l.done {
+			bps += l.bps
+			active++
+		}
+	}
+
+	if unknown {
+		total = 0
+	}
+	if total <= 0 && current <= 0 {
+		return
+	}
+
+	var eta int64 = 0
+	if bps > 0 && total > 0 {
+		eta = int64(float64(total-current) / bps)
+	}
+	fmt.Fprintf(m.out, "[%d active/%d] %s\n", active, len(m.lines), FormatInfo(current, total, bps, eta))
+}
+
+// text shown for single download
+func (l *progressLine) text() string {
+	switch {
+	case l.failed:
+		return "failed"
+	case l.done:
+		return "done"
+	case l.total > 0 || l.current > 0:
+		return FormatInfo(l.current, l.total, l.bps, l.eta)
+	default:
+		return "waiting"
+	}
+}
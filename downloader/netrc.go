@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// one machine entry of .netrc, default entry has empty name
+type netrcMachine struct {
+	name      string
+	isDefault bool
+	login     string
+	password  string
+}
+
+// parse .netrc content, macdef bodies and # comments are skipped, tokens
+// may be spread over several lines
+func parseNetrc(data string) []netrcMachine {
+	var tokens []string
+	inMacro := false
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		// macro definition ends with empty line
+		if inMacro {
+			inMacro = len(fields) > 0
+			continue
+		}
+		for _, field := range fields {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+			if field == "macdef" {
+				inMacro = true
+				break
+			}
+			tokens = append(tokens, field)
+		}
+	}
+
+	var machines []netrcMachine
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			machines = append(machines, netrcMachine{})
+			if i+1 < len(tokens) {
+				i++
+				machines[len(machines)-1].name = tokens[i]
+			}
+		case "default":
+			machines = append(machines, netrcMachine{isDefault: true})
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				break
+			}
+			i++
+			if len(machines) == 0 {
+				continue
+			}
+			m := &machines[len(machines)-1]
+			if tokens[i-1] == "login" {
+				m.login = tokens[i]
+			} else if tokens[i-1] == "password" {
+				m.password = tokens[i]
+			}
+		}
+	}
+	return machines
+}
+
+// entry for host, machine with host name wins over default entry
+func lookupNetrc(machines []netrcMachine, host string) (netrcMachine, bool) {
+	var fallback *netrcMachine
+	for i, m := range machines {
+		if !m.isDefault && strings.EqualFold(m.name, host) {
+			return m, true
+		}
+		if m.isDefault && fallback == nil {
+			fallback = &machines[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return netrcMachine{}, false
+}
+
+// path of .netrc used when NetrcPath is empty
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// machines of .netrc, file is read once per Downloader, missing file means
+// no credentials and file readable by other users is used with warning
+func (d *Downloader) netrc() []netrcMachine {
+	d.netrcOnce.Do(func() {
+		path := d.NetrcPath
+		if path == "" {
+			path = defaultNetrcPath()
+		}
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				d.logger().Warn("can't read netrc file", "file", path, "error", err)
+			}
+			return
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o044 != 0 {
+			d.logger().Warn("netrc file is readable by other users", "file", path)
+			if !d.Quiet {
+				fmt.Fprintf(os.Stderr, "warning: %s is readable by other users, restrict it with chmod 600\n", path)
+			}
+		}
+		d.netrcMachines = parseNetrc(string(data))
+		d.debugf("loaded %d netrc entries from %s", len(d.netrcMachines), path)
+	})
+	return d.netrcMachines
+}
+
+// login and password for host from .netrc when UseNetrc is set and no
+// explicit credentials are given
+func (d *Downloader) netrcAuth(host string) (login, password string, ok bool) {
+	if !d.UseNetrc || d.BearerToken != "" || d.Username != "" || d.Password != "" {
+		return "", "", false
+	}
+	m, ok := lookupNetrc(d.netrc(), host)
+	if !ok || (m.login == "" && m.password == "") {
+		return "", "", false
+	}
+	return m.login, m.password, true
+}
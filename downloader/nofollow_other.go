@@ -0,0 +1,6 @@
+//go:build !unix
+
+package downloader
+
+// no O_NOFOLLOW here, symlinks are rejected only by Lstat check in openFile
+const oNoFollow = 0
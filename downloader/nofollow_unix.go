@@ -0,0 +1,8 @@
+//go:build unix
+
+package downloader
+
+import "syscall"
+
+// flag making open fail when final path component is a symlink
+const oNoFollow = syscall.O_NOFOLLOW
@@ -0,0 +1,392 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// returned by segment when server answered with full content instead of
+// requested range, its data can't be placed at segment offset
+var errSegmentNotPartial = errors.New("server returned full content for segment request")
+
+// download file over several connections at once, every connection fetches
+// its own contiguous segment via Range and writes it at correct offset,
+// when server doesn't support ranges it falls back to single stream Download
+// with its retries
+func (d *Downloader) DownloadParallel(connections int) error {
+	return d.DownloadParallelContext(context.Background(), connections)
+}
+
+// same as DownloadParallel, cancelling ctx stops all connections
+func (d *Downloader) DownloadParallelContext(ctx context.Context, connections int) (err error) {
+
+	// tracked downloads report their final state to shared progress display
+	if d.display != nil {
+		defer func() { d.display.finish(err) }()
+	}
+	defer func() { d.notify(err) }()
+
+	ctx, cancel := d.limitDuration(ctx)
+	defer cancel()
+	defer func() { err = durationErr(ctx, err) }()
+	defer d.closeProgress()
+	d.startSession()
+
+	// segments need request built from Url, file name is resolved from
+	// response of single stream download
+	if connections <= 1 || d.Source != nil || d.RequestFunc != nil || isFTP(d.Url) || d.FilePath == "" || d.streaming() {
+		return d.downloadStream(ctx)
+	}
+
+	if err := d.preparePaths(); err != nil {
+		return err
+	}
+	// pipe found by preparePaths takes data only in order
+	if d.streaming() {
+		return d.downloadStream(ctx)
+	}
+
+	// segments can't continue single stream partial download, resume it instead
+	offset, err := d.resolveStartState()
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		return d.downloadStream(ctx)
+	}
+
+	// conditional request is made only by single stream download
+	if _, ok := d.localFile(); ok && d.conditional() {
+		return d.downloadStream(ctx)
+	}
+
+	httpClient, err := d.httpClient()
+	if err != nil {
+		return err
+	}
+	info, err := d.inspect(ctx, httpClient)
+	if err != nil {
+		return d.tlsVersionError(err)
+	}
+	d.debugf("probe: size %d, accepts ranges %v", info.Size, info.AcceptsRanges)
+	if !info.AcceptsRanges || info.Size <= 0 {
+		return d.downloadStream(ctx)
+	}
+	if d.AutoParallel && info.Size <= d.parallelThreshold() {
+		d.debugf("using 1 connection for %s file", FormatSize(info.Size, true))
+		return d.downloadStream(ctx)
+	}
+	d.debugf("using %d connections for %s file", connections, FormatSize(info.Size, true))
+	if err := d.checkMaxBytes(info.Size); err != nil {
+		return err
+	}
+	d.TotalSize = info.Size
+	d.ContentType = info.ContentType
+	d.LastModified = info.LastModified
+
+	// weak ETag can't tell whether segments of previous run belong to the
+	// same file
+	validator := info.ETag
+	if strings.HasPrefix(validator, "W/") {
+		validator = ""
+	}
+
+	if err := d.resolveChecksumURL(ctx); err != nil {
+		return err
+	}
+	err = d.downloadSegments(ctx, httpClient, connections, validator)
+
+	// some servers (e.g. load balanced ones) send full content for some
+	// segments and changed file is sent whole for If-Range, such download
+	// is restarted over single connection
+	if errors.Is(err, errSegmentNotPartial) {
+		d.removeSegments()
+		atomic.StoreInt64(&d.Downloaded, 0)
+		d.ResumedAt = 0
+		return d.downloadStream(ctx)
+	}
+	return err
+}
+
+// size up to which AutoParallel downloads file over single connection
+const defaultParallelThreshold = 50 << 20
+
+func (d *Downloader) parallelThreshold() int64 {
+	if d.ParallelThreshold > 0 {
+		return d.ParallelThreshold
+	}
+	return defaultParallelThreshold
+}
+
+// contiguous byte range start..end (inclusive) fetched by one connection,
+// done bytes of it are already downloaded
+type segment struct {
+	start, end int64
+	done       int64
+}
+
+func (s segment) size() int64 {
+	return s.end - s.start + 1
+}
+
+// split size bytes into equal segments, last one takes the remainder
+func splitSegments(size int64, connections int) []segment {
+	segmentSize := size / int64(connections)
+	segs := make([]segment, connections)
+	for i := range segs {
+		segs[i].start = int64(i) * segmentSize
+		segs[i].end = segs[i].start + segmentSize - 1
+	}
+	segs[connections-1].end = size - 1
+	return segs
+}
+
+// split file into segments and download them concurrently, every segment
+// goes to its own part file so interrupted download resumes per segment
+// and parts are merged into output file once all are complete, block
+// device is written directly at segment offsets
+func (d *Downloader) downloadSegments(ctx context.Context, httpClient *http.Client, connections int, validator string) (err error) {
+	d.hasher = nil // segments arrive out of order, file is hashed after download
+
+	var segs []segment
+	var writers []io.WriterAt
+	if d.isDevice {
+		d.OutputFile, err = d.openFile(d.dataPath(), os.O_CREATE|os.O_WRONLY)
+		if err != nil {
+			return err
+		}
+		defer d.OutputFile.Close()
+		if err := d.checkDeviceSize(); err != nil {
+			return err
+		}
+		segs = splitSegments(d.TotalSize, connections)
+		for range segs {
+			writers = append(writers, offsetWriter{d.OutputFile, 0})
+		}
+	} else {
+		var files []*os.File
+		files, err = d.openSegments(connections, validator)
+
+		// interrupted download keeps exact size of every part, it is
+		// resume position of its segment
+		defer func() {
+			for _, f := range files {
+				if err != nil {
+					f.Sync()
+				}
+				f.Close()
+			}
+		}()
+		if err != nil {
+			return err
+		}
+		segs = d.segments
+		for i, f := range files {
+			writers = append(writers, offsetWriter{f, segs[i].start})
+		}
+	}
+
+	var done int64
+	for _, seg := range segs {
+		done += seg.done
+	}
+	atomic.StoreInt64(&d.Downloaded, done)
+	d.ResumedAt = done
+
+	stopChan := make(chan struct{}) // this channel signal end of downloading
+	d.ManageProgressPrinter(stopChan)
+	defer close(stopChan)
+
+	d.printf("Downloading from: %s\n", d.Url)
+	d.printf("Downloading to: ./%s using %d connections\n", d.FilePath, len(segs))
+	if d.ResumedAt > 0 {
+		d.debugf("resuming segments from %d of %d bytes", d.ResumedAt, d.TotalSize)
+	}
+
+	// first failed segment cancels the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limiter := d.segmentLimiter()
+	errs := make([]error, len(segs))
+	var wg sync.WaitGroup
+
+	// redownload is counted per segment, segments of earlier Download call
+	// are matched by start offset, every goroutine owns its mark
+	if d.segmentHighWater == nil {
+		d.segmentHighWater = make(map[int64]int64)
+	}
+	marks := make([]int64, len(segs))
+	for i, seg := range segs {
+		marks[i] = max(d.segmentHighWater[seg.start], seg.start+seg.done)
+	}
+	defer func() {
+		for i, seg := range segs {
+			d.segmentHighWater[seg.start] = marks[i]
+		}
+	}()
+
+	for i, seg := range segs {
+		if seg.done == seg.size() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, seg segment) {
+			defer wg.Done()
+			errs[i] = d.downloadSegment(ctx, httpClient, seg, writers[i], validator, limiter, &marks[i])
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, seg)
+	}
+	wg.Wait()
+
+	// report cause of failure, not cancellations it triggered in other segments
+	for _, segErr := range errs {
+		if segErr != nil && !errors.Is(segErr, context.Canceled) {
+			return segErr
+		}
+	}
+	for _, segErr := range errs {
+		if segErr != nil {
+			return segErr
+		}
+	}
+
+	if d.isDevice {
+		if err := d.OutputFile.Sync(); err != nil {
+			return err
+		}
+	} else {
+		if err := d.mergeSegments(); err != nil {
+			return err
+		}
+		defer d.OutputFile.Close()
+	}
+	return d.finalize()
+}
+
+// limiter shared by all segments of download, SpeedLimit caps their
+// combined rate unless Limiter shared with other downloads is set
+func (d *Downloader) segmentLimiter() *RateLimiter {
+	if d.Limiter != nil || d.SpeedLimit <= 0 {
+		return d.Limiter
+	}
+	limiter := NewRateLimiter(d.SpeedLimit)
+	limiter.clock = d.clock
+	return limiter
+}
+
+// how many times segment request answered with full content is repeated
+const fullContentRetries = 2
+
+// perform segment request, backend of load balanced server that ignores
+// Range answers with full content which is dropped unread, that closes the
+// connection so repeated request likely reaches other backend, full
+// content for If-Range means changed file and is not repeated
+func (d *Downloader) segmentResponse(httpClient *http.Client, req *http.Request, conditional bool) (*http.Response, error) {
+	for tries := 0; ; tries++ {
+		resp, err := d.do(httpClient, req)
+		if err != nil || resp.StatusCode != http.StatusOK || conditional || tries == fullContentRetries {
+			return resp, err
+		}
+		resp.Body.Close()
+		d.debugf("full content for segment request %s, repeating it", req.Header.Get("Range"))
+	}
+}
+
+// writes data of segment starting at base offset of file, device is shared
+// by all segments with base 0, part file holds only its segment
+type offsetWriter struct {
+	f    *os.File
+	base int64
+}
+
+func (w offsetWriter) WriteAt(p []byte, offset int64) (int, error) {
+	return w.f.WriteAt(p, offset-w.base)
+}
+
+func (w offsetWriter) Sync() error {
+	return w.f.Sync()
+}
+
+// download rest of segment starting after its done bytes and write it at
+// its offset, resumed segment is requested only when remote file is
+// unchanged, highWater is furthest offset segment ever wrote
+func (d *Downloader) downloadSegment(ctx context.Context, httpClient *http.Client, seg segment, w io.WriterAt, validator string, limiter *RateLimiter, highWater *int64) error {
+	ctx, watchdog := d.watchIdle(ctx)
+	defer watchdog.stop()
+
+	start, end := seg.start, seg.end
+	req, err := d.newRequest(ctx, "GET")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("%s=%d-%d", d.rangeUnit(), start+seg.done, end))
+	if seg.done > 0 && validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := d.segmentResponse(httpClient, req, seg.done > 0 && validator != "")
+	if err != nil {
+		return watchdog.err(ctx, readResult(ctx, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return errSegmentNotPartial
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("segment %d-%d: %w", start, end, d.badStatus(resp))
+	}
+
+	buf, release := d.getBuffer()
+	defer release()
+
+	body := d.pausable(ctx, watchdog, watchdog.reader(resp.Body))
+	offset := start + seg.done
+	persistedAt := offset
+	for {
+		n, readErr := body.Read(buf[:limiter.readSize(len(buf))])
+		if n > 0 {
+			if offset+int64(n) > end+1 {
+				return fmt.Errorf("server sent more data than requested for segment %d-%d", start, end)
+			}
+			if err := d.checkChunk(n); err != nil {
+				return err
+			}
+			if _, writeErr := w.WriteAt(buf[:n], offset); writeErr != nil {
+				return writeErr
+			}
+			if _, err := d.countChunk(offset, n, highWater); err != nil {
+				return err
+			}
+			offset += int64(n)
+			if syncer, ok := w.(interface{ Sync() error }); ok && d.PersistEveryBytes > 0 && offset-persistedAt >= d.PersistEveryBytes {
+				if err := syncer.Sync(); err != nil {
+					return err
+				}
+				persistedAt = offset
+			}
+			if err := limiter.WaitN(ctx, n); err != nil {
+				return err
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				return watchdog.err(ctx, readResult(ctx, readErr))
+			}
+			if offset != end+1 {
+				return fmt.Errorf("segment %d-%d ended early at %d: %w", start, end, offset, io.ErrUnexpectedEOF)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server of load balanced file, every backend sends Accept-Ranges but
+// requests for which ignoreRange is true reach backend sending full content,
+// whole file requested without Range is counted in full
+func newMixedRangeServer(t *testing.T, data []byte, ignoreRange func(r *http.Request) bool, full *int64) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+			atomic.AddInt64(full, 1)
+		}
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" && ignoreRange(r) {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Write(data)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestParallelSegmentWithFullContentIsRepeated(t *testing.T) {
+	data := testData(256 << 10)
+
+	// first request of every segment reaches backend ignoring Range
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var full int64
+	srv := newMixedRangeServer(t, data, func(r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		first := !seen[r.Header.Get("Range")]
+		seen[r.Header.Get("Range")] = true
+		return first
+	}, &full)
+
+	d := newTestDownloader(t, srv.URL)
+	if err := d.DownloadParallel(4); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 4 {
+		t.Fatalf("got requests for %d segments, want 4", len(seen))
+	}
+	if full != 0 {
+		t.Error("download fell back to single connection instead of repeating segment requests")
+	}
+	checkFile(t, d.FilePath, data)
+	if parts, _ := filepath.Glob(d.FilePath + "*"); len(parts) != 1 {
+		t.Errorf("files left next to output: %v", parts)
+	}
+}
+
+func TestParallelFallsBackWhenSegmentsGetFullContent(t *testing.T) {
+	data := testData(256 << 10)
+	var full int64
+	srv := newMixedRangeServer(t, data, func(r *http.Request) bool { return true }, &full)
+
+	d := newTestDownloader(t, srv.URL)
+	if err := d.DownloadParallel(4); err != nil {
+		t.Fatal(err)
+	}
+	if full != 1 {
+		t.Errorf("whole file was requested %d times, want once after segments failed", full)
+	}
+	checkFile(t, d.FilePath, data)
+	for i := range 4 {
+		if _, err := os.Stat(d.segmentPath(i)); err == nil {
+			t.Errorf("part %d left behind", i)
+		}
+	}
+}
+
+func TestParallelFallbackRetries(t *testing.T) {
+	data := testData(64 << 10)
+
+	// server without Range support failing first GET
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		if atomic.AddInt32(&gets, 1) == 1 {
+			w.Header().Del("Content-Length")
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	if err := d.DownloadParallel(4); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if n := atomic.LoadInt32(&gets); n != 2 {
+		t.Fatalf("server got %d GET requests, want 2", n)
+	}
+}
+
+func TestParallelFallbackRestartsOnVerifyFailure(t *testing.T) {
+	data := testData(64 << 10)
+	corrupt := bytes.Clone(data)
+	corrupt[100] ^= 0xff
+
+	// server without Range support sending corrupted data first
+	var gets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		if atomic.AddInt32(&gets, 1) == 1 {
+			w.Write(corrupt)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	d.ExpectedChecksum = "sha256:" + sha256Hex(data)
+	d.OnVerifyFailure = RestartOnVerifyFailure
+	if err := d.DownloadParallel(4); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if n := atomic.LoadInt32(&gets); n != 2 {
+		t.Fatalf("server got %d GET requests, want 2", n)
+	}
+}
+
+func TestParallelSpeedLimitCapsAllConnections(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes seconds of real time")
+	}
+	const limit = 1 << 20
+	data := testData(2 << 20)
+	srv := newFileServer(t, data)
+
+	d := newTestDownloader(t, srv.URL)
+	d.SpeedLimit = limit
+	start := time.Now()
+	if err := d.DownloadParallel(4); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	checkFile(t, d.FilePath, data)
+
+	// 4 connections with own limit would take a quarter of this
+	want := time.Duration(float64(len(data))/limit*float64(time.Second)) +
+		time.Duration((1-rampStart)/2*float64(rampDuration))
+	if elapsed < want*95/100 || elapsed > want*115/100 {
+		t.Fatalf("download took %s, want about %s", elapsed.Round(time.Millisecond), want)
+	}
+}
+
+// ResponseWriter passing only limit bytes of body, the handler is then
+// aborted so the connection is cut in the middle of response
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestParallelCountsRedownload(t *testing.T) {
+	data := testData(256 << 10)
+
+	// first version cuts every segment after 10000 bytes, then file gets
+	// new ETag so parts of the first run can't be resumed
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag.Load().(string))
+		if r.Method == http.MethodGet && etag.Load() == `"v1"` {
+			w = &cutWriter{ResponseWriter: w, limit: 10000}
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	if err := d.DownloadParallel(4); err == nil {
+		t.Fatal("cut segments were accepted")
+	}
+	// first cut segment cancels the others, so amount varies
+	first := d.Downloaded
+	if first <= 0 || first > 4*10000 {
+		t.Fatalf("first run downloaded %d bytes, want up to 4 segments of 10000", first)
+	}
+
+	etag.Store(`"v2"`)
+	if err := d.DownloadParallel(4); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if d.Redownloaded != first {
+		t.Errorf("redownloaded %d bytes, want %d written by first run", d.Redownloaded, first)
+	}
+
+	// ratio limit is checked by segments too
+	d = newTestDownloader(t, srv.URL)
+	etag.Store(`"v1"`)
+	d.DownloadParallel(4)
+	etag.Store(`"v2"`)
+	d.MaxRedownloadRatio = 0.02
+	var limitErr *RedownloadLimitError
+	if err := d.DownloadParallel(4); !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want RedownloadLimitError", err)
+	}
+}
+
+func TestRangeStopsAtMaxBytes(t *testing.T) {
+	data := testData(64 << 10)
+	d := newTestDownloader(t, newFileServer(t, data).URL)
+	d.MaxBytes = 10000
+	d.BufferSize = 1024
+	var out bytes.Buffer
+	err := d.DownloadRange(&out, 1000, 40000)
+	if !errors.Is(err, ErrMaxBytesExceeded) {
+		t.Fatalf("got %v, want ErrMaxBytesExceeded", err)
+	}
+	if out.Len() > 10000 || out.Len() <= 10000-1024 {
+		t.Errorf("%d bytes written with limit 10000", out.Len())
+	}
+}
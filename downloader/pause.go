@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// holds reading of response body while download is paused
+type pauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // closed by Resume, nil while not paused
+}
+
+// stop consuming response body until Resume, connection is kept open so
+// download usually continues right where it stopped, idle Timeout doesn't
+// run while paused and connection closed by server meanwhile is retried
+// from downloaded position after Resume
+func (d *Downloader) Pause() {
+	d.gate.mu.Lock()
+	defer d.gate.mu.Unlock()
+	if d.gate.resume == nil {
+		d.gate.resume = make(chan struct{})
+		d.logger().Info("download paused", "url", d.Url)
+	}
+}
+
+// continue download stopped by Pause
+func (d *Downloader) Resume() {
+	d.gate.mu.Lock()
+	defer d.gate.mu.Unlock()
+	if d.gate.resume != nil {
+		close(d.gate.resume)
+		d.gate.resume = nil
+		d.logger().Info("download resumed", "url", d.Url)
+	}
+}
+
+// reports whether download is paused
+func (d *Downloader) Paused() bool {
+	d.gate.mu.Lock()
+	defer d.gate.mu.Unlock()
+	return d.gate.resume != nil
+}
+
+// block while paused, idle watchdog is suspended meanwhile so pause isn't
+// taken for stalled server
+func (d *Downloader) waitResumed(ctx context.Context, w *idleWatchdog) error {
+	d.gate.mu.Lock()
+	resume := d.gate.resume
+	d.gate.mu.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	w.suspend()
+	defer w.restart()
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wrap body so every read waits while download is paused
+func (d *Downloader) pausable(ctx context.Context, w *idleWatchdog, r io.Reader) io.Reader {
+	return &pausableReader{r: r, d: d, ctx: ctx, w: w}
+}
+
+type pausableReader struct {
+	r   io.Reader
+	d   *Downloader
+	ctx context.Context
+	w   *idleWatchdog
+}
+
+func (r *pausableReader) Read(p []byte) (int, error) {
+	if err := r.d.waitResumed(r.ctx, r.w); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// results of inspect shared by downloads of Queue, keyed by URL, entry
+// older than maxAge or contradicted by later response is inspected again,
+// methods of nil cache do nothing so downloads outside Queue skip it
+type probeCache struct {
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]probeEntry
+}
+
+type probeEntry struct {
+	info FileInfo
+	at   time.Time
+}
+
+func newProbeCache(maxAge time.Duration) *probeCache {
+	return &probeCache{maxAge: maxAge, now: time.Now, entries: make(map[string]probeEntry)}
+}
+
+// copy of recent result for url, caller may modify it
+func (c *probeCache) get(url string) (*FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+	if c.now().Sub(entry.at) > c.maxAge {
+		delete(c.entries, url)
+		return nil, false
+	}
+	info := entry.info
+	return &info, true
+}
+
+func (c *probeCache) put(url string, info *FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entries[url] = probeEntry{info: *info, at: c.now()}
+	c.mu.Unlock()
+}
+
+// drop url, e.g. after its download failed and remote may have changed
+func (c *probeCache) forget(url string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, url)
+	c.mu.Unlock()
+}
+
+// drop url when response of its download shows other file than the cached
+// one, size is total size of file or -1 when response doesn't tell
+func (c *probeCache) observe(url string, resp *http.Response, size int64) {
+	info, ok := c.get(url)
+	if !ok {
+		return
+	}
+	etag := resp.Header.Get("ETag")
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	switch {
+	case size >= 0 && info.Size >= 0 && size != info.Size,
+		etag != "" && info.ETag != "" && etag != info.ETag,
+		!lastModified.IsZero() && !info.LastModified.IsZero() && !lastModified.Equal(info.LastModified):
+		c.forget(url)
+	}
+}
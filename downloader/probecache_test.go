@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueReusesProbes(t *testing.T) {
+	data := testData(64 << 10)
+	files := newFileServer(t, data)
+	var heads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var urls []string
+	for i := range 3 {
+		urls = append(urls, srv.URL+"/file"+strconv.Itoa(i)+".bin")
+	}
+
+	// plan, overall progress and parallel download all inspect every URL
+	q := &Queue{Config: Config{OutputDir: dir, Quiet: true}, Overall: true, Connections: 2, ProbeMaxAge: time.Minute}
+	q.Plan(context.Background(), urls)
+	if err := q.Download(context.Background(), urls); err != nil {
+		t.Fatal(err)
+	}
+	if heads != int32(len(urls)) {
+		t.Errorf("server got %d HEAD requests, want one per URL", heads)
+	}
+	for i := range urls {
+		checkFile(t, filepath.Join(dir, "file"+strconv.Itoa(i)+".bin"), data)
+	}
+}
+
+func TestProbeCacheExpires(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newProbeCache(time.Minute)
+	c.now = func() time.Time { return now }
+	c.put("http://example.com/a", &FileInfo{Size: 10})
+
+	now = now.Add(time.Minute)
+	info, ok := c.get("http://example.com/a")
+	if !ok || info.Size != 10 {
+		t.Fatalf("got %v %v, want cached size 10", info, ok)
+	}
+	info.Size = 20 // caller's copy
+	if info, _ := c.get("http://example.com/a"); info.Size != 10 {
+		t.Errorf("cached size changed to %d", info.Size)
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.get("http://example.com/a"); ok {
+		t.Error("result older than max age was reused")
+	}
+
+	var empty *probeCache
+	empty.put("http://example.com/a", &FileInfo{})
+	if _, ok := empty.get("http://example.com/a"); ok {
+		t.Error("nil cache returned result")
+	}
+}
+
+func TestQueueForgetsProbeOfChangedFile(t *testing.T) {
+	old := testData(32 << 10)
+	current := testData(40 << 10)
+	var changed atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := old
+		if changed.Load() {
+			data = current
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	url := srv.URL + "/file.bin"
+	q := &Queue{Config: Config{OutputDir: dir, Quiet: true}, ProbeMaxAge: time.Minute}
+	if plan := q.Plan(context.Background(), []string{url}); plan[0].Size != int64(len(old)) {
+		t.Fatalf("planned size %d, want %d", plan[0].Size, len(old))
+	}
+	if _, ok := q.probes.get(url); !ok {
+		t.Fatal("plan didn't cache its result")
+	}
+
+	// download sees other size than plan did
+	changed.Store(true)
+	if err := q.Download(context.Background(), []string{url}); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(dir, "file.bin"), current)
+	if _, ok := q.probes.get(url); ok {
+		t.Error("result contradicted by download was kept")
+	}
+}
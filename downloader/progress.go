@@ -0,0 +1,51 @@
+package downloader
+
+// snapshot of download progress sent on ProgressChan
+type Progress struct {
+	Downloaded int64
+	Total      int64   // 0 when size is unknown
+	BPS        float64 // speed in byte/s
+	ETA        int64   // remaining seconds
+	Percent    float64 // 0 when size is unknown
+	Paused     bool    // download is stopped by Pause
+	Stalled    bool    // no data arrived for last few seconds
+}
+
+// channel receiving progress every ProgressInterval, snapshot is dropped when
+// receiver is not ready so slow consumer never blocks download, channel is
+// closed when Download returns and next call creates new one
+func (d *Downloader) ProgressChan() <-chan Progress {
+	d.progressChMu.Lock()
+	defer d.progressChMu.Unlock()
+	if d.progressCh == nil {
+		d.progressCh = make(chan Progress, 1)
+	}
+	return d.progressCh
+}
+
+// send snapshot to ProgressChan without waiting
+func (d *Downloader) sendProgress(current, total int64, bps float64, eta int64) {
+	d.progressChMu.Lock()
+	defer d.progressChMu.Unlock()
+	if d.progressCh == nil {
+		return
+	}
+	p := Progress{Downloaded: current, Total: total, BPS: bps, ETA: eta, Paused: d.Paused(), Stalled: d.stalled()}
+	if total > 0 {
+		p.Percent = float64(current) / float64(total) * 100
+	}
+	select {
+	case d.progressCh <- p:
+	default:
+	}
+}
+
+// close ProgressChan at end of download
+func (d *Downloader) closeProgress() {
+	d.progressChMu.Lock()
+	defer d.progressChMu.Unlock()
+	if d.progressCh != nil {
+		close(d.progressCh)
+		d.progressCh = nil
+	}
+}
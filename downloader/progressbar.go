@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	defaultTermWidth = 80 // used when width of terminal can't be detected
+	minBarWidth      = 10 // narrower bar is not drawn at all
+)
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// state of single line progress output on stdout
+type progressBar struct {
+	frame    int       // spinner frame shown when total size is unknown
+	lastLine time.Time // when last plain line was printed to non terminal
+	paused   bool      // download is paused, position is shown without speed
+	stalled  bool      // no data arrive, position is shown without speed
+}
+
+// print progress of download to out, terminal gets bar redrawn in place,
+// redirected output gets plain line every aggregateInterval instead
+func (b *progressBar) print(out *os.File, current, total int64, bps float64, eta int64) {
+	if !isTerminal(out) {
+		if time.Since(b.lastLine) < aggregateInterval {
+			return
+		}
+		b.lastLine = time.Now()
+		fmt.Fprintln(out, b.info(current, total, bps, eta))
+		return
+	}
+
+	width, _, err := term.GetSize(int(out.Fd()))
+	if err != nil || width <= 0 {
+		width = defaultTermWidth
+	}
+	fmt.Fprintf(out, "\r\033[2K%s", b.line(current, total, bps, eta, width))
+}
+
+// progress fields without bar, unknown total is shown with spinner
+func (b *progressBar) info(current, total int64, bps float64, eta int64) string {
+	if b.paused || b.stalled {
+		state := "Paused"
+		if !b.paused {
+			state = "Stalled"
+		}
+		if total <= 0 {
+			return state + " at " + FormatSize(current, true)
+		}
+		return fmt.Sprintf("%s at %.2f%% %s/%s", state, float64(current)/float64(total)*100,
+			FormatSize(current, true), FormatSize(total, true))
+	}
+	if total <= 0 {
+		b.frame = (b.frame + 1) % len(spinnerFrames)
+		return spinnerFrames[b.frame] + " " + FormatInfo(current, total, bps, eta)
+	}
+	return FormatInfo(current, total, bps, eta)
+}
+
+// bar followed by progress fields, all fitting into width columns
+func (b *progressBar) line(current, total int64, bps float64, eta int64, width int) string {
+	info := b.info(current, total, bps, eta)
+	if total <= 0 {
+		return info
+	}
+
+	// one column is left free so cursor doesn't wrap to next line
+	barWidth := width - len(info) - 4
+	if barWidth < minBarWidth {
+		return info
+	}
+	return FormatBar(current, total, barWidth) + " " + info
+}
+
+// format bar like "[=====>    ]" with inner width columns
+func FormatBar(current, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(float64(min(current, total)) / float64(total) * float64(width))
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('[')
+	switch {
+	case filled >= width:
+		sb.WriteString(strings.Repeat("=", width))
+	case filled > 0:
+		sb.WriteString(strings.Repeat("=", filled-1))
+		sb.WriteByte('>')
+		sb.WriteString(strings.Repeat(" ", width-filled))
+	default:
+		sb.WriteString(strings.Repeat(" ", width))
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
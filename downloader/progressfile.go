@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// content of progress file, older files hold only byte count optionally
+// followed by validator on second line, newer ones are key=value lines so
+// other tools can show how far download got
+type ProgressState struct {
+	Downloaded int64
+	Total      int64  // 0 when size was unknown or file is in old format
+	Validator  string // ETag or Last-Modified used as If-Range on resume
+	URL        string // source of download without query, password is masked
+}
+
+// percentage of file downloaded, 0 when total size is unknown
+func (s ProgressState) Percent() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	return float64(s.Downloaded) / float64(s.Total) * 100
+}
+
+// read progress file written by Downloader, both old and current format
+func LoadProgress(path string) (ProgressState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ProgressState{}, err
+	}
+	state, ok := parseProgress(string(data))
+	if !ok {
+		return ProgressState{}, fmt.Errorf("invalid progress file %s", path)
+	}
+	return state, nil
+}
+
+func parseProgress(data string) (state ProgressState, ok bool) {
+	first, rest, _ := strings.Cut(data, "\n")
+	if !strings.Contains(first, "=") {
+		n, err := strconv.ParseInt(first, 10, 64)
+		state = ProgressState{Downloaded: n, Validator: strings.TrimSpace(rest)}
+		return state, err == nil && n >= 0
+	}
+
+	found := false
+	for _, line := range strings.Split(data, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		var err error
+		switch key {
+		case "downloaded":
+			state.Downloaded, err = strconv.ParseInt(value, 10, 64)
+			found = err == nil && state.Downloaded >= 0
+		case "total":
+			state.Total, err = strconv.ParseInt(value, 10, 64)
+		case "validator":
+			state.Validator = value
+		case "url":
+			state.URL = value
+		}
+		if err != nil {
+			return ProgressState{}, false
+		}
+	}
+	return state, found
+}
+
+// content of progress file for current downloaded byte count
+func (d *Downloader) formatProgress(current int64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "downloaded=%d\ntotal=%d\n", current, max(d.TotalSize, 0))
+	if d.ResumeValidator != "" {
+		fmt.Fprintf(&b, "validator=%s\n", d.ResumeValidator)
+	}
+	if u, err := url.Parse(d.Url); err == nil && d.Url != "" {
+		// query of signed URL carries token that stays valid for a while,
+		// progress file lying around must not hand it to anyone
+		u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = "", false, "", ""
+		fmt.Fprintf(&b, "url=%s\n", u.Redacted())
+	}
+	return b.String()
+}
@@ -0,0 +1,414 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Queue downloads URLs one after another (or Jobs of them at once) with the
+// same options, failed download doesn't stop the rest of the queue
+type Queue struct {
+	// options applied to every download, URL is set per download and
+	// FilePath is ignored, file names come from server response or URL,
+	// SpeedLimit applies to the whole queue
+	Config Config
+
+	// inspect all URLs first and show progress of whole queue instead of
+	// single file, OnOverallProgress receives it instead of printing
+	Overall           bool
+	OnOverallProgress func(QueueProgress)
+
+	// number of files downloaded at the same time, 0 or 1 downloads them
+	// one after another, with more jobs progress of running files is shown
+	// by MultiProgress and all URLs are inspected first, URL whose output
+	// file is written by earlier URL fails with ErrOutputConflict
+	Jobs int
+	// connections used for every file, more than 1 uses DownloadParallel
+	Connections int
+	// reuse size and name of URL inspected by Plan, Overall or parallel
+	// download this long ago instead of asking server again, 0 always asks
+	ProbeMaxAge time.Duration
+
+	client  *http.Client // shared by downloads so keep-alive connections are reused
+	limiter *RateLimiter // made from SpeedLimit and shared by downloads
+	buffers *BufferPool  // read buffers of all downloads and their segments
+	probes  *probeCache  // inspect results kept for ProbeMaxAge
+	display *MultiProgress
+
+	mu       sync.Mutex
+	sizes    []int64   // inspected size of every URL, -1 when unknown
+	current  []int64   // downloaded bytes of running downloads with known size
+	speeds   []float64 // speed of running downloads
+	overall  QueueProgress
+	outMu    sync.Mutex
+	lastLine time.Time // when last plain line was printed to non terminal
+}
+
+// combined progress of all files of Queue
+type QueueProgress struct {
+	File       int     // number of file being downloaded, starting at 1
+	Files      int     // number of files in queue
+	Downloaded int64   // bytes of files with known size, finished ones included
+	Total      int64   // sum of known file sizes, failed files are removed from it
+	Unknown    int     // files of unknown size left out of Total
+	BPS        float64 // speed of running downloads in byte/s
+	ETA        int64   // remaining seconds of whole queue, 0 when unknown
+}
+
+// share of Total downloaded in percent, 0 when no size is known
+func (p QueueProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Downloaded) / float64(p.Total) * 100
+}
+
+// format queue progress like "3 of 10 files, 42.00% overall ...", "~" marks
+// percentage that leaves out files of unknown size
+func FormatQueueProgress(p QueueProgress) string {
+	if p.Total <= 0 {
+		return fmt.Sprintf("%d of %d files  DS: %s", p.File, p.Files, FormatSpeed(p.BPS))
+	}
+	approx := ""
+	if p.Unknown > 0 {
+		approx = "~"
+	}
+	return fmt.Sprintf("%d of %d files, %s%.2f%% overall %s/%s  DS: %s ETA: %s",
+		p.File, p.Files, approx, p.Percent(),
+		FormatSize(p.Downloaded, true), FormatSize(p.Total, true),
+		FormatSpeed(p.BPS), FormatEtaLong(p.ETA))
+}
+
+// download all urls sequentially into dir, errors of failed downloads are
+// joined and returned after the whole queue is processed
+func DownloadAll(urls []string, dir string) error {
+	q := &Queue{Config: Config{OutputDir: dir, UseProgressFile: true}}
+	return q.Download(context.Background(), urls)
+}
+
+// download urls in order, Jobs of them at the same time, cancelling ctx
+// stops the queue, too many open files needed by Jobs and Connections is
+// reported before anything is downloaded
+func (q *Queue) Download(ctx context.Context, urls []string) error {
+	jobs := q.jobs(len(urls))
+	if err := q.checkOpenFiles(jobs); err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	q.prepare(urls[0], jobs)
+	var infos []*FileInfo
+	if q.Overall || jobs > 1 {
+		infos = q.inspectAll(ctx, urls)
+	}
+	if q.Overall {
+		q.startOverall(infos)
+	}
+	conflicts := q.conflicts(urls, infos)
+
+	errs := make([]error, len(urls))
+	slots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	var cancelled error
+	for i, url := range urls {
+		// concurrent jobs would share part file of the same output
+		if conflicts[i] != nil {
+			errs[i] = fmt.Errorf("%s: %w", url, conflicts[i])
+			if q.Overall {
+				q.fileDone(i, conflicts[i])
+			}
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			cancelled = err
+			break
+		}
+		if !q.Config.Quiet && jobs == 1 {
+			fmt.Fprintf(q.out(), "[%d/%d] %s\n", i+1, len(urls), url)
+		}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := q.download(ctx, i, url)
+			if err != nil {
+				// remote may have changed, next attempt asks again
+				q.probes.forget(url)
+				errs[i] = fmt.Errorf("%s: %w", url, err)
+			}
+			if q.Overall {
+				q.fileDone(i, err)
+			}
+		}(i, url)
+	}
+	wg.Wait()
+	if q.Overall {
+		q.finished()
+	}
+
+	if q.client != nil {
+		q.client.CloseIdleConnections()
+	}
+	return errors.Join(append(errs, cancelled)...)
+}
+
+// queue messages and progress go where downloads print theirs
+func (q *Queue) out() *os.File {
+	if q.Config.MessagesToStderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// number of concurrent downloads for n urls
+func (q *Queue) jobs(n int) int {
+	return max(min(q.Jobs, n), 1)
+}
+
+// open files needed by one download: connection and part file of every
+// segment, output file, progress file and record of segments
+func filesPerDownload(connections int) int64 {
+	return 2*int64(connections) + 3
+}
+
+// files kept for stdio, DNS lookups, idle keep-alive connections and the
+// program using Queue
+const reservedFiles = 32
+
+// refuse Jobs and Connections that would run out of file descriptors, such
+// download would otherwise fail somewhere in the middle with "too many open files"
+func (q *Queue) checkOpenFiles(jobs int) error {
+	limit := openFileLimit()
+	if limit <= 0 {
+		return nil
+	}
+	connections := max(q.Connections, 1)
+	need := reservedFiles + int64(jobs)*filesPerDownload(connections)
+	if need > limit {
+		return &OpenFilesError{Need: need, Limit: limit, Jobs: jobs, Connections: connections}
+	}
+	return nil
+}
+
+// create client, limiter and buffer pool shared by all downloads before
+// they run concurrently, several jobs are shown by MultiProgress
+func (q *Queue) prepare(url string, jobs int) {
+	cfg := q.Config
+	cfg.URL = url
+	if q.client == nil {
+		if d, err := NewDownloaderWithConfig(cfg); err == nil {
+			q.client, _ = d.httpClient()
+		}
+	}
+	if cfg.Buffers == nil && q.buffers == nil {
+		size := cfg.BufferSize
+		if size <= 0 {
+			size = defaultBufferSize
+		}
+		q.buffers = NewBufferPool(size)
+	}
+	// adaptive limit backs off on its own, so it stays with the download
+	if cfg.Limiter == nil && cfg.SpeedLimit > 0 && !cfg.AdaptiveLimit && q.limiter == nil {
+		q.limiter = NewRateLimiter(cfg.SpeedLimit)
+	}
+	q.prepareProbes()
+	if jobs > 1 && !q.Overall && !cfg.Quiet && cfg.OnProgress == nil && q.display == nil {
+		q.display = NewMultiProgress(q.out())
+	}
+}
+
+// create cache of inspect results once, ProbeMaxAge set later doesn't
+// apply to it
+func (q *Queue) prepareProbes() {
+	if q.ProbeMaxAge > 0 && q.probes == nil {
+		q.probes = newProbeCache(q.ProbeMaxAge)
+	}
+}
+
+func (q *Queue) download(ctx context.Context, i int, url string) error {
+	cfg := q.Config
+	cfg.URL = url
+	cfg.FilePath = ""
+	if q.Overall {
+		onProgress := cfg.OnProgress
+		cfg.OnProgress = func(downloaded, total int64, bps float64, eta int64) {
+			if onProgress != nil {
+				onProgress(downloaded, total, bps, eta)
+			}
+			q.progress(i, downloaded, bps)
+		}
+	}
+	if q.limiter != nil {
+		cfg.Limiter = q.limiter
+		cfg.SpeedLimit = 0
+	}
+	if cfg.Buffers == nil {
+		cfg.Buffers = q.buffers
+	}
+
+	d, err := NewDownloaderWithConfig(cfg)
+	if err != nil {
+		return err
+	}
+	d.client = q.client
+	d.probes = q.probes
+	if q.display != nil {
+		q.display.Track(d)
+	}
+	if q.Connections > 1 {
+		return d.DownloadParallelContext(ctx, q.Connections)
+	}
+	return d.DownloadContext(ctx)
+}
+
+// inspect all files with HEAD requests for their size and name, failed
+// inspection leaves its entry nil and the download itself reports the error
+func (q *Queue) inspectAll(ctx context.Context, urls []string) []*FileInfo {
+	infos := make([]*FileInfo, len(urls))
+	for i, url := range urls {
+		cfg := q.Config
+		cfg.URL = url
+		d, err := NewDownloaderWithConfig(cfg)
+		if err != nil {
+			continue
+		}
+		d.client = q.client
+		d.probes = q.probes
+		if info, err := d.InspectContext(ctx); err == nil {
+			infos[i] = info
+		}
+	}
+	return infos
+}
+
+// set up overall progress from inspected files, unknown size is left out
+// of Total
+func (q *Queue) startOverall(infos []*FileInfo) {
+	q.sizes = make([]int64, len(infos))
+	q.current = make([]int64, len(infos))
+	q.speeds = make([]float64, len(infos))
+	q.overall = QueueProgress{Files: len(infos)}
+	for i, info := range infos {
+		q.sizes[i] = -1
+		if info == nil || info.Size < 0 {
+			q.overall.Unknown++
+			continue
+		}
+		q.sizes[i] = info.Size
+		q.overall.Total += info.Size
+	}
+}
+
+// ErrOutputConflict for every URL whose output file is written by earlier
+// URL, same check as PlanConflict of Plan, file of failed inspection can't
+// be checked
+func (q *Queue) conflicts(urls []string, infos []*FileInfo) []error {
+	errs := make([]error, len(urls))
+	written := make(map[string]string)
+	for i, info := range infos {
+		if info == nil {
+			continue
+		}
+		path, err := sanitizeOutputPath(q.Config.OutputDir, info.Filename)
+		if err != nil {
+			continue
+		}
+		if earlier, ok := written[path]; ok {
+			errs[i] = fmt.Errorf("%s: %w %s", path, ErrOutputConflict, earlier)
+			continue
+		}
+		written[path] = urls[i]
+	}
+	return errs
+}
+
+// update and show queue progress with downloaded bytes of file i, bytes
+// and speed of other running downloads are included
+func (q *Queue) progress(i int, downloaded int64, bps float64) {
+	q.mu.Lock()
+	if q.sizes[i] >= 0 {
+		q.current[i] = min(downloaded, q.sizes[i])
+	}
+	q.speeds[i] = bps
+	p := q.snapshot(i)
+	q.mu.Unlock()
+	q.show(p, false)
+}
+
+// queue progress while file i is downloaded, caller holds mu
+func (q *Queue) snapshot(i int) QueueProgress {
+	p := q.overall
+	p.File = i + 1
+	for j := range q.current {
+		p.Downloaded += q.current[j]
+		p.BPS += q.speeds[j]
+	}
+	if p.BPS > 0 {
+		p.ETA = int64(float64(p.Total-p.Downloaded) / p.BPS)
+	}
+	return p
+}
+
+// pass queue progress to OnOverallProgress or print it, concurrent jobs
+// take turns, final update isn't throttled on non terminal
+func (q *Queue) show(p QueueProgress, final bool) {
+	q.outMu.Lock()
+	defer q.outMu.Unlock()
+	if q.OnOverallProgress != nil {
+		q.OnOverallProgress(p)
+		return
+	}
+	if q.Config.Quiet {
+		return
+	}
+	if !isTerminal(q.out()) {
+		if !final && time.Since(q.lastLine) < aggregateInterval {
+			return
+		}
+		q.lastLine = time.Now()
+		fmt.Fprintln(q.out(), FormatQueueProgress(p))
+		return
+	}
+	fmt.Fprintf(q.out(), "\r\033[2K%s", FormatQueueProgress(p))
+}
+
+// account for finished file and show progress with it, failed one no
+// longer counts into Total, last progress of file can arrive before its
+// end so without this update queue would look stuck short of finished file
+func (q *Queue) fileDone(i int, err error) {
+	q.mu.Lock()
+	q.speeds[i] = 0
+	if q.sizes[i] >= 0 {
+		q.current[i] = 0
+		if err != nil {
+			q.overall.Total -= q.sizes[i]
+		} else {
+			q.overall.Downloaded += q.sizes[i]
+		}
+	}
+	p := q.snapshot(i)
+	q.mu.Unlock()
+	q.show(p, false)
+}
+
+// show final progress of whole queue once all downloads ended, progress
+// line redrawn in place is then ended
+func (q *Queue) finished() {
+	q.mu.Lock()
+	p := q.snapshot(len(q.sizes) - 1)
+	q.mu.Unlock()
+	q.show(p, true)
+	if !q.Config.Quiet && q.OnOverallProgress == nil && isTerminal(q.out()) {
+		fmt.Fprintln(q.out())
+	}
+}
@@ -0,0 +1,227 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueJobsRunConcurrently(t *testing.T) {
+	data := testData(32 << 10)
+	files := newFileServer(t, data)
+
+	// every download waits until another one is running as well, files
+	// are inspected one after another first
+	var running, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			files.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for atomic.LoadInt32(&peak) < 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var urls []string
+	for i := range 4 {
+		urls = append(urls, srv.URL+"/file"+strconv.Itoa(i)+".bin")
+	}
+	q := &Queue{Config: Config{OutputDir: dir, Quiet: true}, Jobs: 2}
+	if err := q.Download(context.Background(), urls); err != nil {
+		t.Fatal(err)
+	}
+	if peak != 2 {
+		t.Errorf("%d downloads ran at once, want 2", peak)
+	}
+	for i := range 4 {
+		checkFile(t, filepath.Join(dir, "file"+strconv.Itoa(i)+".bin"), data)
+	}
+}
+
+func TestQueueFailedDownloadDoesNotStopOthers(t *testing.T) {
+	data := testData(1000)
+	files := newFileServer(t, data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	q := &Queue{Config: Config{OutputDir: dir, Quiet: true}, Jobs: 3}
+	err := q.Download(context.Background(), []string{srv.URL + "/a.bin", srv.URL + "/missing.bin", srv.URL + "/b.bin"})
+	if !errors.Is(err, ErrBadStatus) {
+		t.Fatalf("got %v, want error of missing file", err)
+	}
+	checkFile(t, filepath.Join(dir, "a.bin"), data)
+	checkFile(t, filepath.Join(dir, "b.bin"), data)
+	if _, err := os.Stat(filepath.Join(dir, "missing.bin")); err == nil {
+		t.Error("missing file was created")
+	}
+}
+
+func TestQueueRefusesTooManyOpenFiles(t *testing.T) {
+	limit := openFileLimit()
+	if limit <= 0 {
+		t.Skip("limit of open files is not known on this platform")
+	}
+	jobs := int(limit/8 + 1) // every job with 4 connections needs 11 files
+	q := &Queue{Config: Config{Quiet: true}, Jobs: jobs, Connections: 4}
+	urls := make([]string, jobs)
+	for i := range urls {
+		urls[i] = "http://127.0.0.1:1/" + strconv.Itoa(i)
+	}
+
+	err := q.Download(context.Background(), urls)
+	var filesErr *OpenFilesError
+	if !errors.As(err, &filesErr) || !errors.Is(err, ErrTooManyOpenFiles) {
+		t.Fatalf("got %v, want OpenFilesError", err)
+	}
+	if filesErr.Limit != limit || filesErr.Need <= limit {
+		t.Errorf("got need %d and limit %d, want need over limit %d", filesErr.Need, filesErr.Limit, limit)
+	}
+}
+
+func TestQueueOverallProgressAfterEachFile(t *testing.T) {
+	data := testData(32 << 10)
+	size := int64(len(data))
+	files := newFileServer(t, data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// periodic updates never come, only those of finished files, missing
+	// file fails inspection so it is counted as unknown
+	var updates []QueueProgress
+	q := &Queue{
+		Config:            Config{OutputDir: t.TempDir(), Quiet: true, ProgressInterval: time.Hour},
+		Overall:           true,
+		OnOverallProgress: func(p QueueProgress) { updates = append(updates, p) },
+	}
+	urls := []string{srv.URL + "/a.bin", srv.URL + "/missing.bin", srv.URL + "/b.bin", srv.URL + "/c.bin"}
+	if err := q.Download(context.Background(), urls); !errors.Is(err, ErrBadStatus) {
+		t.Fatalf("got %v, want error of missing file", err)
+	}
+
+	want := []QueueProgress{
+		{File: 1, Files: 4, Downloaded: size, Total: 3 * size, Unknown: 1},
+		{File: 2, Files: 4, Downloaded: size, Total: 3 * size, Unknown: 1},
+		{File: 3, Files: 4, Downloaded: 2 * size, Total: 3 * size, Unknown: 1},
+		{File: 4, Files: 4, Downloaded: 3 * size, Total: 3 * size, Unknown: 1},
+		{File: 4, Files: 4, Downloaded: 3 * size, Total: 3 * size, Unknown: 1}, // queue finished
+	}
+	if len(updates) != len(want) {
+		t.Fatalf("got %d updates %+v, want %d", len(updates), updates, len(want))
+	}
+	for i := range want {
+		if updates[i] != want[i] {
+			t.Errorf("update %d is %+v, want %+v", i+1, updates[i], want[i])
+		}
+	}
+}
+
+func TestQueueSharesBufferPool(t *testing.T) {
+	data := testData(256 << 10)
+	srv := newFileServer(t, data)
+
+	const files, jobs, connections = 6, 2, 4
+	var urls []string
+	for i := range files {
+		urls = append(urls, srv.URL+"/file"+strconv.Itoa(i)+".bin")
+	}
+	dir := t.TempDir()
+	q := &Queue{Config: Config{OutputDir: dir, Quiet: true}, Jobs: jobs, Connections: connections}
+
+	// count buffers the pool had to allocate
+	q.buffers = NewBufferPool(defaultBufferSize)
+	var allocated int32
+	q.buffers.pool.New = func() any {
+		atomic.AddInt32(&allocated, 1)
+		buf := make([]byte, defaultBufferSize)
+		return &buf
+	}
+	if err := q.Download(context.Background(), urls); err != nil {
+		t.Fatal(err)
+	}
+	for i := range files {
+		checkFile(t, filepath.Join(dir, "file"+strconv.Itoa(i)+".bin"), data)
+	}
+	// every segment of every file got buffer from the pool, at most those
+	// running at once need their own (sync.Pool may drop some under -race)
+	if allocated == 0 || allocated >= files*connections {
+		t.Errorf("pool allocated %d buffers for %d segments", allocated, files*connections)
+	}
+}
+
+func TestQueueCreatesBufferPool(t *testing.T) {
+	srv := newFileServer(t, testData(1000))
+	q := &Queue{Config: Config{OutputDir: t.TempDir(), Quiet: true, BufferSize: 4096}}
+	if err := q.Download(context.Background(), []string{srv.URL + "/a.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if q.buffers == nil || q.buffers.size != 4096 {
+		t.Fatalf("queue pool %+v, want pool of BufferSize", q.buffers)
+	}
+
+	// pool given by caller is used instead
+	own := NewBufferPool(defaultBufferSize)
+	q = &Queue{Config: Config{OutputDir: t.TempDir(), Quiet: true, Buffers: own}}
+	if err := q.Download(context.Background(), []string{srv.URL + "/a.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if q.buffers != nil {
+		t.Fatal("queue created pool although Config has one")
+	}
+}
+
+func TestQueueRefusesConflictingJobs(t *testing.T) {
+	data := testData(32 << 10)
+	files := newFileServer(t, data)
+	var gets sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Store(r.URL.Path, true)
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// both URLs are saved as file.bin, concurrent jobs would share its part file
+	dir := t.TempDir()
+	urls := []string{srv.URL + "/a/file.bin", srv.URL + "/b/file.bin", srv.URL + "/other.bin"}
+	q := &Queue{Config: Config{OutputDir: dir, Quiet: true}, Jobs: 3}
+	err := q.Download(context.Background(), urls)
+	if !errors.Is(err, ErrOutputConflict) {
+		t.Fatalf("got %v, want ErrOutputConflict", err)
+	}
+	if _, ok := gets.Load("/b/file.bin"); ok {
+		t.Error("conflicting URL was downloaded")
+	}
+	checkFile(t, filepath.Join(dir, "file.bin"), data)
+	checkFile(t, filepath.Join(dir, "other.bin"), data)
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// write bytes start to end (both inclusive) of remote file to w, meant for
+// callers doing their own segmentation or writing to sink that can't seek
+// (pipe, multipart upload), w only ever receives data of the range in order,
+// retried attempt asks for the rest of the range, Downloaded holds number of
+// bytes written to w so far, negative end reads until end of file whose size
+// is then found out first
+func (d *Downloader) DownloadRange(w io.Writer, start, end int64) error {
+	return d.DownloadRangeContext(context.Background(), w, start, end)
+}
+
+// same as DownloadRange, cancelling ctx stops download
+func (d *Downloader) DownloadRangeContext(ctx context.Context, w io.Writer, start, end int64) (err error) {
+	if start < 0 || (end >= 0 && end < start) {
+		return fmt.Errorf("invalid range %d-%d", start, end)
+	}
+	if d.Source != nil || isFTP(d.Url) {
+		return fmt.Errorf("%w: range download needs HTTP URL", ErrRangeNotSupported)
+	}
+	httpClient, err := d.httpClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := d.limitDuration(ctx)
+	defer cancel()
+	defer func() { err = durationErr(ctx, err) }()
+	d.startSession()
+	defer d.endSession()
+	atomic.StoreInt64(&d.Downloaded, 0)
+
+	if end < 0 {
+		info, err := d.inspect(ctx, httpClient)
+		if err != nil {
+			return err
+		}
+		if info.Size < 0 {
+			return fmt.Errorf("range %d-: size of file is unknown", start)
+		}
+		if start >= info.Size {
+			return fmt.Errorf("range %d-: file has only %d bytes", start, info.Size)
+		}
+		end = info.Size - 1
+	}
+
+	seg := segment{start: start, end: end}
+	sink := &appendWriter{w: w, offset: start}
+	limiter := d.segmentLimiter() // retries keep the same limit
+	highWater := start
+	for attempt := 0; ; attempt++ {
+		err := d.downloadSegment(ctx, httpClient, seg, sink, "", limiter, &highWater)
+		seg.done = sink.offset - start
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errSegmentNotPartial) {
+			return fmt.Errorf("range %d-%d: %w", start, end, ErrRangeNotSupported)
+		}
+		if ctx.Err() != nil || attempt >= d.MaxRetries || !d.retryable(err) {
+			return err
+		}
+
+		delay := d.backoff(attempt, err)
+		atomic.AddInt64(&d.retries, 1)
+		d.logger().Warn("retrying range", "url", d.Url, "offset", sink.offset, "attempt", attempt+1, "delay", delay, "error", err)
+		d.debugf("range %d-%d failed at %d: %v, retrying in %s", start, end, sink.offset, err, delay.Round(time.Millisecond))
+		if err := d.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// io.WriterAt over writer that can only append, segment is written forward
+// so every write must continue where previous one ended
+type appendWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (a *appendWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off != a.offset {
+		return 0, fmt.Errorf("write at %d, sink is at %d", off, a.offset)
+	}
+	n, err := a.w.Write(p)
+	a.offset += int64(n)
+	return n, err
+}
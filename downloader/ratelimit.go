@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limit ramps up from rampStart of its rate to full rate over rampDuration
+// after first read, so start of download doesn't burst at line rate
+const (
+	rampDuration = 2 * time.Second
+	rampStart    = 0.25
+)
+
+// token bucket limiting throughput to rate byte/s, bucket starts empty and
+// holds at most burst bytes so idle period can't be followed by unlimited
+// burst, tokens are taken after read and may go negative which is repaid
+// by sleeping, that keeps long term rate exact regardless of read sizes
+type tokenBucket struct {
+	rate   float64 // byte/s
+	burst  float64 // bucket capacity in bytes
+	tokens float64
+	last   time.Time
+	start  time.Time // first use, ramp is measured from it
+}
+
+func newTokenBucket(rate int64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:  float64(rate),
+		burst: float64(burst),
+		last:  now,
+	}
+}
+
+// rate and burst allowed at now, both grow linearly during ramp
+func (b *tokenBucket) current(now time.Time) (rate, burst float64) {
+	if b.start.IsZero() {
+		b.start, b.last = now, now
+	}
+	f := 1.0
+	if elapsed := now.Sub(b.start); elapsed < rampDuration {
+		f = rampStart + (1-rampStart)*elapsed.Seconds()/rampDuration.Seconds()
+	}
+	return b.rate * f, b.burst * f
+}
+
+// take n bytes from bucket and return how long to wait until debt is repaid
+func (b *tokenBucket) take(now time.Time, n int) time.Duration {
+	rate, burst := b.current(now)
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*rate, burst)
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// largest read of at most n bytes that fits into current burst, reads stay
+// small while limit ramps up
+func (b *tokenBucket) readSize(now time.Time, n int) int {
+	_, burst := b.current(now)
+	return max(min(n, int(burst)), 1)
+}
+
+// RateLimiter caps combined throughput of all downloaders holding it, e.g.
+// whole Queue or downloads shown by one MultiProgress
+type RateLimiter struct {
+	rate   int64
+	mu     sync.Mutex
+	bucket *tokenBucket
+	clock
+}
+
+// create limiter allowing bytesPerSecond byte/s, nil is returned for
+// bytesPerSecond <= 0 and nil limiter doesn't limit anything
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   bytesPerSecond,
+		bucket: newTokenBucket(bytesPerSecond, int(min(bytesPerSecond, defaultBufferSize)), time.Now()),
+	}
+}
+
+// account for n bytes that were just read and wait until they fit into the
+// limit, cancelling ctx stops waiting
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	wait := l.bucket.take(l.now(), n)
+	l.mu.Unlock()
+	if wait > 0 {
+		return l.sleep(ctx, wait)
+	}
+	return nil
+}
+
+// largest read of at most n bytes allowed by limiter right now
+func (l *RateLimiter) readSize(n int) int {
+	if l == nil {
+		return n
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bucket.readSize(l.now(), n)
+}
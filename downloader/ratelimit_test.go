@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSpeedLimitRate(t *testing.T) {
+	data := testData(4 << 20)
+	for _, limit := range []int64{1_000_000, 1_234_567, 300_000} {
+		fc := newFakeClock()
+		tl := newTimeline(fc)
+		d := &Downloader{sink: tl}
+		d.clock = fc.clock()
+		if err := d.DownloadChunksWithLimit(context.Background(), bytes.NewReader(data), limit); err != nil {
+			t.Fatal(err)
+		}
+
+		// ramp is over after rampDuration, rate is exact from then on
+		rate := tl.rateSince(rampDuration)
+		if math.Abs(rate-float64(limit)) > 0.05*float64(limit) {
+			t.Errorf("limit %d: measured %.0f byte/s after ramp", limit, rate)
+		}
+		// whole download including ramp is never faster than limit
+		elapsed := fc.Now().Sub(tl.start).Seconds()
+		if overall := float64(len(data)) / elapsed; overall > float64(limit)*1.05 {
+			t.Errorf("limit %d: overall %.0f byte/s", limit, overall)
+		}
+	}
+}
+
+func TestSpeedLimitDownload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes seconds of real time")
+	}
+	const limit = 1 << 20
+	data := testData(3 << 20)
+	srv := newFileServer(t, data)
+
+	d := newTestDownloader(t, srv.URL)
+	d.SpeedLimit = limit
+	start := time.Now()
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	checkFile(t, d.FilePath, data)
+
+	// ramp from rampStart to full rate costs part of rampDuration
+	want := time.Duration(float64(len(data))/limit*float64(time.Second)) +
+		time.Duration((1-rampStart)/2*float64(rampDuration))
+	if diff := math.Abs(float64(elapsed - want)); diff > 0.05*float64(want) {
+		t.Fatalf("download took %s, want %s within 5%%", elapsed.Round(time.Millisecond), want)
+	}
+}
+
+// cost of accounting one read in token bucket, time is virtual so only
+// the bookkeeping is measured, parallel run shows lock contention of
+// limiter shared by many connections
+func BenchmarkRateLimiter(b *testing.B) {
+	for _, size := range []int{4 << 10, 32 << 10} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			l := NewRateLimiter(100 << 20)
+			l.clock = newFakeClock().clock()
+			ctx := context.Background()
+			b.SetBytes(int64(size))
+			for range b.N {
+				l.WaitN(ctx, l.readSize(size))
+			}
+		})
+	}
+	b.Run("Parallel", func(b *testing.B) {
+		l := NewRateLimiter(100 << 20)
+		l.clock = newFakeClock().clock()
+		ctx := context.Background()
+		b.SetBytes(32 << 10)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				l.WaitN(ctx, l.readSize(32<<10))
+			}
+		})
+	})
+}
+
+func TestSpeedLimitHasNoBurst(t *testing.T) {
+	const window = 100 * time.Millisecond
+	data := testData(5_000_000)
+	for _, tt := range []struct {
+		limit  int64
+		shared bool // Limiter is used instead of SpeedLimit
+	}{
+		{1_000_000, false},
+		{100_000, false},
+		{10_000, false},
+		{1_000_000, true},
+		{100_000, true},
+	} {
+		limit := tt.limit
+		fc := newFakeClock()
+		tl := newTimeline(fc)
+		d := &Downloader{sink: tl}
+		d.clock = fc.clock()
+		speedLimit := limit
+		if tt.shared {
+			d.Limiter = NewRateLimiter(limit)
+			d.Limiter.clock = d.clock
+			speedLimit = 0
+		}
+		body := bytes.NewReader(data[:limit*5]) // five seconds of data
+		if err := d.DownloadChunksWithLimit(context.Background(), body, speedLimit); err != nil {
+			t.Fatal(err)
+		}
+
+		burst := min(limit, defaultBufferSize)
+		allowed := int64(float64(limit)*window.Seconds()) + burst
+		if most := tl.maxWindow(window); most > allowed {
+			t.Errorf("limit %d: %d bytes within %s, allowed %d", limit, most, window, allowed)
+		}
+
+		// ramp starts at rampStart of limit, first half second gets far
+		// less than half second worth of full rate
+		const start = 500 * time.Millisecond
+		var early int64
+		for i, at := range tl.at {
+			if at < start {
+				early = tl.total[i]
+			}
+		}
+		if fullRate := int64(float64(limit) * start.Seconds()); early > fullRate/2+burst {
+			t.Errorf("limit %d: %d bytes in first %s, ramp should allow about %d", limit, early, start, fullRate/3)
+		}
+	}
+}
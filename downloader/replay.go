@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// RecordTransport performs requests with Next (http.DefaultTransport when
+// nil) and saves every response (headers + body) into Dir, so tricky server
+// behavior can be reproduced later by ReplayTransport
+type RecordTransport struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+func (t *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	f, err := os.Create(recordingPath(t.Dir, req))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// Transfer-Encoding is not recorded because body is stored decoded,
+	// replayed body without length then simply ends at end of file
+	fmt.Fprintf(f, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	if err := resp.Header.Write(f); err != nil {
+		f.Close()
+		resp.Body.Close()
+		return nil, err
+	}
+	io.WriteString(f, "\r\n")
+
+	// body is recorded while caller reads it, so connection closed in the
+	// middle of transfer is recorded as truncated body
+	resp.Body = &recordingBody{Reader: io.TeeReader(resp.Body, f), body: resp.Body, file: f}
+	return resp, nil
+}
+
+// response body copying everything read into recording file
+type recordingBody struct {
+	io.Reader
+	body io.Closer
+	file *os.File
+}
+
+func (b *recordingBody) Close() error {
+	b.file.Close()
+	return b.body.Close()
+}
+
+// ReplayTransport answers requests from responses saved by RecordTransport
+// in Dir without touching network, missing recording is an error
+type ReplayTransport struct {
+	Dir string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := recordingPath(t.Dir, req)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("no recording for %s %s: %w", req.Method, req.URL, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(f), req)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading recording %s: %w", path, err)
+	}
+	resp.Body = &replayBody{ReadCloser: resp.Body, file: f}
+	return resp, nil
+}
+
+// response body that also closes recording file
+type replayBody struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (b *replayBody) Close() error {
+	b.file.Close()
+	return b.ReadCloser.Close()
+}
+
+// recording file name, Range is part of key so resumed requests get their
+// own recordings
+func recordingPath(dir string, req *http.Request) string {
+	key := req.Method + " " + req.URL.String() + " " + req.Header.Get("Range")
+	return filepath.Join(dir, fmt.Sprintf("%x.http", sha256.Sum256([]byte(key))))
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// read recording of GET request with Range header, empty for none
+func readRecording(t *testing.T, dir, url, byteRange string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	f, err := os.Open(recordingPath(dir, req))
+	if err != nil {
+		t.Fatalf("request with Range %q was not recorded: %v", byteRange, err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// body is read raw, recording of truncated transfer is shorter than its
+	// Content-Length
+	resp.Body.Close()
+	f.Seek(0, io.SeekStart)
+	raw, _ := io.ReadAll(f)
+	_, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+	return resp, []byte(body)
+}
+
+func TestRecordReplayTruncatedBody(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newShortBodyServer(t, data, 10000)
+	dir := t.TempDir()
+
+	d := newTestDownloader(t, srv.URL)
+	d.Transport = &RecordTransport{Dir: dir}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	recordedRetries := atomic.LoadInt64(&d.retries)
+	if recordedRetries != 1 {
+		t.Fatalf("recorded download retried %d times, want 1", recordedRetries)
+	}
+
+	// first response announces whole file but its body ends early
+	resp, body := readRecording(t, dir, srv.URL, "")
+	if resp.ContentLength != int64(len(data)) || len(body) != 10000 {
+		t.Errorf("recorded %d of %d bytes, want 10000 of %d", len(body), resp.ContentLength, len(data))
+	}
+	resp, body = readRecording(t, dir, srv.URL, "bytes=10000-")
+	if resp.StatusCode != http.StatusPartialContent || string(body) != string(data[10000:]) {
+		t.Errorf("resumed request recorded status %d with %d bytes", resp.StatusCode, len(body))
+	}
+
+	// replay without server retries at the same offset
+	url := srv.URL
+	srv.Close()
+	d = newTestDownloader(t, url)
+	d.Transport = &ReplayTransport{Dir: dir}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if retries := atomic.LoadInt64(&d.retries); retries != recordedRetries {
+		t.Errorf("replayed download retried %d times, want %d", retries, recordedRetries)
+	}
+}
+
+func TestRecordReplayWrongContentRange(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newContentRangeServer(t, data, 0, fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+	dir := t.TempDir()
+	const want = "server sent data from offset 0 instead of requested 20000"
+
+	d := newTestDownloader(t, srv.URL)
+	d.Transport = &RecordTransport{Dir: dir}
+	writePartial(t, d, data, 20000)
+	err := d.Download()
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+	recordedRetries := atomic.LoadInt64(&d.retries)
+	resp, _ := readRecording(t, dir, srv.URL, "bytes=20000-")
+	if got := resp.Header.Get("Content-Range"); !strings.HasPrefix(got, "bytes 0-") {
+		t.Errorf("recorded Content-Range %q", got)
+	}
+
+	url := srv.URL
+	srv.Close()
+	d = newTestDownloader(t, url)
+	d.Transport = &ReplayTransport{Dir: dir}
+	writePartial(t, d, data, 20000)
+	err = d.Download()
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("replay got %v, want %q", err, want)
+	}
+	if retries := atomic.LoadInt64(&d.retries); retries != recordedRetries {
+		t.Errorf("replayed download retried %d times, want %d", retries, recordedRetries)
+	}
+	checkFile(t, d.FilePath+partSuffix, data[:20000])
+}
@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = time.Second
+	maxRetryDelay         = time.Minute // backoff never waits longer than this
+	defaultMaxRetryAfter  = time.Minute
+)
+
+// run download attempts until one succeeds, fails with permanent error or
+// MaxRetries is exhausted, every new attempt resumes from downloaded position
+func (d *Downloader) downloadWithRetry(ctx context.Context) error {
+	for attempt := 0; ; attempt++ {
+		err := d.download(ctx)
+		if err == nil {
+			d.debugf("file served by %s", d.Url)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		// next mirror continues from the same position right away
+		if d.switchMirror(ctx, err) {
+			attempt--
+			continue
+		}
+		if attempt >= d.MaxRetries || !d.retryable(err) {
+			return err
+		}
+
+		delay := d.backoff(attempt, err)
+		atomic.AddInt64(&d.retries, 1)
+		d.debugf("attempt %d failed: %v", attempt+1, err)
+		d.logger().Warn("retrying download", "url", d.Url, "attempt", attempt+1, "max_retries", d.MaxRetries, "delay", delay, "error", err)
+		d.printf("\nDownload failed: %v, retrying in %s (%d/%d)\n", err, delay.Round(time.Millisecond), attempt+1, d.MaxRetries)
+		if err := d.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// exponential backoff with jitter, delay doubles every attempt and random
+// half of it is dropped so many clients don't retry in lockstep
+func (d *Downloader) retryDelay(attempt int) time.Duration {
+	base := d.RetryBaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+
+	delay := maxRetryDelay
+	if attempt < 32 {
+		delay = min(base<<attempt, maxRetryDelay)
+	}
+	return delay/2 + d.randN(delay/2+1)
+}
+
+// wait before next attempt, Retry-After of failed response replaces backoff
+func (d *Downloader) backoff(attempt int, err error) time.Duration {
+	var status *BadStatusError
+	if errors.As(err, &status) && status.RetryAfter > 0 {
+		return min(status.RetryAfter, d.maxRetryAfter())
+	}
+	return d.retryDelay(attempt)
+}
+
+// longest Retry-After delay honored, server asking for more can't make
+// client sleep for hours
+func (d *Downloader) maxRetryAfter() time.Duration {
+	if d.MaxRetryAfter > 0 {
+		return d.MaxRetryAfter
+	}
+	return defaultMaxRetryAfter
+}
+
+// BadStatusError for response, Retry-After is parsed for statuses that use
+// it, HTTP date is compared with clock of downloader
+func (d *Downloader) badStatus(resp *http.Response) *BadStatusError {
+	err := &BadStatusError{Code: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), d.now())
+	}
+	return err
+}
+
+// parse Retry-After given as seconds or HTTP date, 0 when missing or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(min(secs, int64(math.MaxInt64/time.Second))) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// transient error or response with one of RetryStatusCodes
+func (d *Downloader) retryable(err error) bool {
+	var status *BadStatusError
+	if errors.As(err, &status) && slices.Contains(d.RetryStatusCodes, status.Code) {
+		return true
+	}
+	return isTransient(err)
+}
+
+// reports whether error is caused by network or server trouble that another
+// attempt can get over, e.g. connection reset, timeout, 429 or 5xx status
+func isTransient(err error) bool {
+	var status *BadStatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500 || status.Code == http.StatusTooManyRequests
+	}
+
+	// request errors are wrapped in url.Error which itself looks like
+	// net.Error, so the real cause has to be examined
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) {
+		return false
+	}
+
+	// 4xx FTP replies are temporary failures
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) {
+		return ftpErr.Code >= 400 && ftpErr.Code < 500
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
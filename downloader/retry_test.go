@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server answering first request with status and the rest with data
+func newFailOnceServer(t *testing.T, data []byte, status int) (*httptest.Server, *int32) {
+	t.Helper()
+	files := newFileServer(t, data)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		files.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestRetryStatusCodes(t *testing.T) {
+	data := testData(16 << 10)
+	srv, requests := newFailOnceServer(t, data, http.StatusRequestTimeout)
+
+	d := newTestDownloader(t, srv.URL)
+	d.RetryStatusCodes = []int{http.StatusTooEarly, http.StatusRequestTimeout}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Fatalf("server got %d requests, want 2", n)
+	}
+}
+
+func TestStatusNotInRetryStatusCodesFails(t *testing.T) {
+	data := testData(16 << 10)
+	srv, requests := newFailOnceServer(t, data, http.StatusRequestTimeout)
+
+	d := newTestDownloader(t, srv.URL)
+	d.RetryStatusCodes = []int{http.StatusTooEarly}
+	err := d.Download()
+	var status *BadStatusError
+	if !errors.As(err, &status) || status.Code != http.StatusRequestTimeout {
+		t.Fatalf("got %v, want 408 status error", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("server got %d requests, want 1", n)
+	}
+}
+
+func TestRetryStatusCodesValidated(t *testing.T) {
+	_, err := NewDownloaderWithConfig(Config{URL: "http://example.com/f", RetryStatusCodes: []int{408, 1000}})
+	if err == nil {
+		t.Fatal("status code 1000 was accepted")
+	}
+}
+
+func TestRetryDelaySequence(t *testing.T) {
+	d := NewDownloader("http://example.com/f", "", false)
+	d.RetryBaseDelay = 100 * time.Millisecond
+	d.rng = rand.New(rand.NewPCG(1, 2))
+	want := rand.New(rand.NewPCG(1, 2))
+
+	for attempt, full := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond,
+		1600 * time.Millisecond, 3200 * time.Millisecond, 6400 * time.Millisecond, 12800 * time.Millisecond,
+		25600 * time.Millisecond, 51200 * time.Millisecond, time.Minute, time.Minute,
+	} {
+		// random half of doubled delay is dropped, maxRetryDelay caps it
+		expected := full/2 + time.Duration(want.Int64N(int64(full/2+1)))
+		got := d.retryDelay(attempt)
+		if got != expected {
+			t.Errorf("attempt %d waits %s, want %s", attempt, got, expected)
+		}
+		if got < full/2 || got > full {
+			t.Errorf("attempt %d waits %s outside of [%s, %s]", attempt, got, full/2, full)
+		}
+	}
+	if got := d.retryDelay(40); got < maxRetryDelay/2 || got > maxRetryDelay {
+		t.Errorf("attempt 40 waits %s, want at most %s", got, maxRetryDelay)
+	}
+}
+
+func TestRetryAfterSequence(t *testing.T) {
+	data := testData(1000)
+	files := newFileServer(t, data)
+	fc := newFakeClock()
+
+	// Retry-After in seconds, as HTTP date of virtual clock, over
+	// MaxRetryAfter and missing (backoff is used)
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", fc.Now().Add(5*time.Second).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 4:
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			files.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	d := newTestDownloader(t, srv.URL)
+	d.MaxRetries = 4
+	d.RetryBaseDelay = time.Second
+	d.MaxRetryAfter = 10 * time.Second
+	var sleeps []time.Duration
+	d.clock = clock{
+		nowFunc:   fc.Now,
+		sleepFunc: func(wait time.Duration) { sleeps = append(sleeps, wait); fc.Sleep(wait) },
+		rng:       rand.New(rand.NewPCG(7, 7)),
+	}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+
+	backoff := rand.New(rand.NewPCG(7, 7))
+	full := time.Second << 3 // fourth attempt
+	want := []time.Duration{2 * time.Second, 5 * time.Second, 10 * time.Second, full/2 + time.Duration(backoff.Int64N(int64(full/2+1)))}
+	if !slices.Equal(sleeps, want) {
+		t.Fatalf("retries waited %v, want %v", sleeps, want)
+	}
+}
+
+// server cutting its first response short and ignoring Range afterwards,
+// retry with RestartOnNoRange then sends the cut bytes again
+func newNoRangeRetryServer(t *testing.T, data []byte, cut int) *httptest.Server {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if atomic.AddInt32(&requests, 1) == 1 {
+			(&cutWriter{ResponseWriter: w, limit: cut}).Write(data)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRetryCountsRedownload(t *testing.T) {
+	data := testData(64 << 10)
+
+	// restarted retry writes first 10000 bytes once more
+	d := newTestDownloader(t, newNoRangeRetryServer(t, data, 10000).URL)
+	d.RestartOnNoRange = true
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if d.Redownloaded != 10000 {
+		t.Errorf("redownloaded %d bytes, want 10000", d.Redownloaded)
+	}
+
+	// ratio below the repeated part aborts restarted retry
+	d = newTestDownloader(t, newNoRangeRetryServer(t, data, 10000).URL)
+	d.RestartOnNoRange = true
+	d.MaxRedownloadRatio = 0.1
+	err := d.Download()
+	var limitErr *RedownloadLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want RedownloadLimitError", err)
+	}
+	if limitErr.TotalSize != int64(len(data)) || limitErr.Redownloaded <= int64(len(data))/10 {
+		t.Errorf("stopped after %d redownloaded bytes of %d, want just over 10%%", limitErr.Redownloaded, limitErr.TotalSize)
+	}
+}
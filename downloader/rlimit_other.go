@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package downloader
+
+// limit of open files is unknown here, check is skipped
+func openFileLimit() int64 {
+	return -1
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package downloader
+
+import "golang.org/x/sys/unix"
+
+// soft limit of open files of process, -1 when it can't be read
+func openFileLimit() int64 {
+	var rl unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rl); err != nil {
+		return -1
+	}
+	// Cur is uint64 on Linux and macOS but int64 on FreeBSD, unlimited
+	// RLIM_INFINITY turns negative or huge either way
+	cur := int64(rl.Cur)
+	if cur < 0 || cur > 1<<62 {
+		return -1
+	}
+	return cur
+}
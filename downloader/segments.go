@@ -0,0 +1,162 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// file holding data of segment i until segments are merged
+func (d *Downloader) segmentPath(i int) string {
+	return d.dataPath() + strconv.Itoa(i)
+}
+
+// record of segment ranges, resumed download keeps them even when number
+// of connections changed, progress of segment is size of its part file
+func (d *Downloader) segmentsPath() string {
+	return d.dataPath() + ".segments"
+}
+
+// store total size, validator and ranges of segments, one per line
+func (d *Downloader) writeSegments(validator string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d\n%s\n", d.TotalSize, validator)
+	for _, seg := range d.segments {
+		fmt.Fprintf(&b, "%d-%d\n", seg.start, seg.end)
+	}
+	return os.WriteFile(d.segmentsPath(), []byte(b.String()), 0644)
+}
+
+// read record written by writeSegments, ok is false when it is missing,
+// damaged or belongs to file of other size or validator
+func (d *Downloader) readSegments(validator string) (segs []segment, ok bool) {
+	data, err := os.ReadFile(d.segmentsPath())
+	if err != nil {
+		return nil, false
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) < 3 {
+		return nil, false
+	}
+	for _, line := range lines[2:] {
+		start, end, found := strings.Cut(line, "-")
+		s, err1 := strconv.ParseInt(start, 10, 64)
+		e, err2 := strconv.ParseInt(end, 10, 64)
+		if !found || err1 != nil || err2 != nil || e < s {
+			return nil, false
+		}
+		segs = append(segs, segment{start: s, end: e})
+	}
+	total, err := strconv.ParseInt(lines[0], 10, 64)
+	return segs, err == nil && total == d.TotalSize && lines[1] == validator
+}
+
+// open part file of every segment, segments recorded by interrupted
+// download of the same file continue where their parts end, otherwise
+// TotalSize is split anew and old parts are dropped
+func (d *Downloader) openSegments(connections int, validator string) ([]*os.File, error) {
+	segs, resumed := d.readSegments(validator)
+	if !d.UseProgressFile || !resumed {
+		d.segments = segs
+		d.removeSegments()
+		d.segments = splitSegments(d.TotalSize, connections)
+		resumed = false
+		if d.UseProgressFile {
+			if err := d.writeSegments(validator); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		d.segments = segs
+	}
+
+	var files []*os.File
+	var done int64
+	for i := range d.segments {
+		seg := &d.segments[i]
+		f, err := d.openFile(d.segmentPath(i), os.O_CREATE|os.O_WRONLY)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+
+		info, err := f.Stat()
+		if err != nil {
+			return files, err
+		}
+
+		// part longer than its segment doesn't match the record, it is
+		// downloaded again
+		seg.done = info.Size()
+		if !resumed || seg.done > seg.size() {
+			seg.done = 0
+			if err := f.Truncate(0); err != nil {
+				return files, err
+			}
+		}
+		done += seg.done
+	}
+
+	// parts are kept until merged output file is synced, merge needs space
+	// for second copy of the file
+	return files, d.requireSpace(2*d.TotalSize - done)
+}
+
+// concatenate complete parts in order into data file, parts are removed
+// only after whole file is merged and synced so crash during merge can't
+// lose data
+func (d *Downloader) mergeSegments() error {
+	// part of wrong size would shift data of all following segments, parts
+	// are kept so resume can complete them
+	for i, seg := range d.segments {
+		info, err := os.Stat(d.segmentPath(i))
+		if err != nil {
+			return err
+		}
+		if info.Size() != seg.size() {
+			return fmt.Errorf("segment %d-%d: part file %s has %d of %d bytes",
+				seg.start, seg.end, d.segmentPath(i), info.Size(), seg.size())
+		}
+	}
+
+	var err error
+	d.OutputFile, err = d.openFile(d.dataPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if d.Preallocate {
+		if err := d.preallocate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range d.segments {
+		part, err := os.Open(d.segmentPath(i))
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(d.OutputFile, part, d.segments[i].size())
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// parts are removed only once merged data are safely on disk
+	if err := d.OutputFile.Sync(); err != nil {
+		return err
+	}
+	d.removeSegments()
+	return nil
+}
+
+// remove part files and record of segments
+func (d *Downloader) removeSegments() {
+	for i := range d.segments {
+		os.Remove(d.segmentPath(i))
+	}
+	os.Remove(d.segmentsPath())
+	d.segments = nil
+}
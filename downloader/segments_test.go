@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// downloader with data split into connections segments whose complete
+// parts are written to disk
+func newSegmentedDownload(t *testing.T, data []byte, connections int) *Downloader {
+	t.Helper()
+	d := newTestDownloader(t, "http://example.com/file.bin")
+	d.TotalSize = int64(len(data))
+	d.segments = splitSegments(d.TotalSize, connections)
+	for i, seg := range d.segments {
+		if err := os.WriteFile(d.segmentPath(i), data[seg.start:seg.end+1], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.writeSegments(""); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestMergeSegments(t *testing.T) {
+	data := testData(100_003)
+	d := newSegmentedDownload(t, data, 4)
+	if err := d.mergeSegments(); err != nil {
+		t.Fatal(err)
+	}
+	d.OutputFile.Close()
+	checkFile(t, d.dataPath(), data)
+
+	for i := range 4 {
+		if _, err := os.Stat(d.segmentPath(i)); !os.IsNotExist(err) {
+			t.Errorf("part %d was not removed: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(d.segmentsPath()); !os.IsNotExist(err) {
+		t.Errorf("record of segments was not removed: %v", err)
+	}
+}
+
+func TestMergeSegmentsRefusesPartOfWrongSize(t *testing.T) {
+	data := testData(100_003)
+	for _, size := range []int64{1000, 30000} {
+		d := newSegmentedDownload(t, data, 4)
+		seg := d.segments[2]
+		if err := os.Truncate(d.segmentPath(2), size); err != nil {
+			t.Fatal(err)
+		}
+
+		err := d.mergeSegments()
+		if err == nil || !strings.Contains(err.Error(), "part file") {
+			t.Fatalf("part of %d bytes for %d byte segment: got %v", size, seg.size(), err)
+		}
+		// nothing is merged and every part stays for resume
+		if _, err := os.Stat(d.dataPath()); !os.IsNotExist(err) {
+			t.Errorf("output file was created: %v", err)
+		}
+		for i := range 4 {
+			if _, err := os.Stat(d.segmentPath(i)); err != nil {
+				t.Errorf("part %d: %v", i, err)
+			}
+		}
+		if _, err := os.Stat(d.segmentsPath()); err != nil {
+			t.Errorf("record of segments: %v", err)
+		}
+	}
+}
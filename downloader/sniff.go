@@ -0,0 +1,48 @@
+package downloader
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// number of bytes used by http.DetectContentType
+const sniffLen = 512
+
+// detect type of downloaded data from its first bytes and warn when server
+// declared binary content but data look like text or HTML, which usually
+// means an error page is being saved instead of the file
+func (d *Downloader) sniffContent(body io.Reader) io.Reader {
+	br := bufio.NewReaderSize(body, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	if len(head) == 0 {
+		return br
+	}
+
+	d.DetectedType = http.DetectContentType(head)
+	if looksSuspicious(d.ContentType, d.DetectedType) {
+		d.printf("Warning: server declared %s but data look like %s, check downloaded file\n",
+			d.ContentType, d.DetectedType)
+	}
+	return br
+}
+
+// declared binary type with textual data is suspicious, textual or unknown
+// declared type is not
+func looksSuspicious(declared, detected string) bool {
+	declaredType, _, err := mime.ParseMediaType(declared)
+	if err != nil || isTextual(declaredType) {
+		return false
+	}
+	detectedType, _, _ := mime.ParseMediaType(detected)
+	return isTextual(detectedType)
+}
+
+func isTextual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+xml")
+}
@@ -0,0 +1,236 @@
+package downloader
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Source provides data for Downloader, it decouples resume, progress and
+// verification from HTTP so any byte source can be downloaded
+type Source interface {
+	// open reader with data starting at offset, size is number of bytes
+	// reader provides or -1 when unknown
+	Open(ctx context.Context, offset int64) (body io.ReadCloser, size int64, err error)
+}
+
+// returned by source when local file is up to date and nothing is downloaded
+var errUpToDate = errors.New("local file is up to date")
+
+// default source downloading Url over HTTP
+type httpSource struct {
+	d *Downloader
+}
+
+func (s *httpSource) Open(ctx context.Context, offset int64) (io.ReadCloser, int64, error) {
+	d := s.d
+
+	// create HTTP client & perform request
+	httpClient, err := d.httpClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := d.fetch(ctx, httpClient)
+	if err != nil {
+		return nil, 0, d.tlsVersionError(err)
+	}
+
+	if d.conditional() && d.upToDate(resp) {
+		resp.Body.Close()
+		return nil, 0, errUpToDate
+	}
+
+	// force quit when servers response in negative
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, 0, d.badStatus(resp)
+	}
+
+	// compressed data can't continue decompressed ones at byte offset, so
+	// download starts again from zero
+	if offset > 0 && contentEncoding(resp) != "" {
+		resp.Body.Close()
+		if d.streaming() {
+			return nil, 0, fmt.Errorf("server sent compressed data for resumed stream")
+		}
+		d.printf("Server sent compressed data for resumed download, downloading it again\n")
+		if d.ProgressPath != "" {
+			os.Remove(d.ProgressPath)
+		}
+		atomic.StoreInt64(&d.Downloaded, 0)
+		d.ResumedAt = 0
+		return s.Open(ctx, 0)
+	}
+
+	// full content for If-Range request means remote file changed since
+	// previous run, old partial data are overwritten from start
+	if offset > 0 && resp.StatusCode == http.StatusOK && resp.Request.Header.Get("If-Range") != "" {
+		if d.streaming() {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("remote file changed while streaming it")
+		}
+		d.printf("Remote file changed since download started, downloading it again\n")
+		d.remoteChanged = true
+		atomic.StoreInt64(&d.Downloaded, 0)
+		d.ResumedAt = 0
+		offset = 0
+	}
+
+	// server ignoring Range sent whole file, with RestartOnNoRange it is
+	// simply downloaded again from start
+	if offset > 0 && resp.StatusCode == http.StatusOK && d.RestartOnNoRange && !d.streaming() {
+		d.printf("Server doesn't support partial downloads, downloading whole file again\n")
+		if d.ProgressPath != "" {
+			os.Remove(d.ProgressPath)
+		}
+		atomic.StoreInt64(&d.Downloaded, 0)
+		d.ResumedAt = 0
+		offset = 0
+	}
+
+	// force quit when server doesn't support partial downloads
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("%w, remove %s to download file again", ErrRangeNotSupported, d.ProgressPath)
+	}
+	// partial content must start exactly at resume offset, otherwise data
+	// would be written misaligned, total size after "/" is authoritative
+	size := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		start, end, total, ok := parseContentRange(resp.Header.Get("Content-Range"), d.rangeUnit())
+		if !ok {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("invalid Content-Range %q in partial response", resp.Header.Get("Content-Range"))
+		}
+		if start != offset {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("server sent data from offset %d instead of requested %d", start, offset)
+		}
+		size = end - start + 1
+		if total >= 0 {
+			size = total - offset
+		}
+		d.probes.observe(d.Url, resp, total)
+	} else if contentEncoding(resp) == "" {
+		d.probes.observe(d.Url, resp, resp.ContentLength)
+	}
+
+	if d.FilePath == "" && d.sink == nil {
+		d.FilePath, err = sanitizeOutputPath(d.OutputDir, resolveFilename(resp, resp.Request.URL.String()))
+		if err != nil {
+			resp.Body.Close()
+			return nil, 0, err
+		}
+		d.debugf("resolved file name %s", d.FilePath)
+	}
+	d.debugf("partial content supported: %v", resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Accept-Ranges") == d.rangeUnit())
+	d.ContentType = resp.Header.Get("Content-Type")
+	d.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	d.ResumeValidator = resumeValidator(resp)
+
+	// net/http decodes gzip only when it asked for it, transport built from
+	// options never does so RequestCompression can ask on its own,
+	// Content-Length is then size of compressed data so size of decoded data
+	// is unknown and decompressed stream can't be resumed
+	d.compressed = false
+	if encoding := contentEncoding(resp); encoding != "" {
+		var decoder io.ReadCloser
+		if encoding == "gzip" {
+			decoder, err = gzip.NewReader(resp.Body)
+		} else {
+			decoder, err = zlib.NewReader(resp.Body)
+		}
+		if err != nil {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("reading %s encoded response: %w", encoding, err)
+		}
+		d.compressed = true
+		return &decodedBody{ReadCloser: decoder, body: resp.Body}, -1, nil
+	}
+
+	// size is known from http header that was send by server
+	return resp.Body, size, nil
+}
+
+// parse "<unit> start-end/total" Content-Range value, total is -1 for "*"
+func parseContentRange(contentRange, unit string) (start, end, total int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(contentRange), unit+" ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	byteRange, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	first, last, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < start {
+		return 0, 0, 0, false
+	}
+	total = -1
+	if size != "*" {
+		var err error
+		total, err = strconv.ParseInt(size, 10, 64)
+		if err != nil || total <= end {
+			return 0, 0, 0, false
+		}
+	}
+	return start, end, total, true
+}
+
+// "gzip" or "deflate" when response body is compressed, empty otherwise
+func contentEncoding(resp *http.Response) string {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		return "gzip"
+	case "deflate":
+		return "deflate"
+	}
+	return ""
+}
+
+// decompressed response body
+type decodedBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
+
+// validator usable in If-Range, weak ETag can't be used there so
+// Last-Modified is taken instead
+func resumeValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// ReaderAtSource provides data from io.ReaderAt of known size, e.g. local
+// file or custom random access storage
+type ReaderAtSource struct {
+	R    io.ReaderAt
+	Size int64
+}
+
+func (s *ReaderAtSource) Open(ctx context.Context, offset int64) (io.ReadCloser, int64, error) {
+	if offset > s.Size {
+		return nil, 0, fmt.Errorf("resume offset %d is past end of source of %d bytes", offset, s.Size)
+	}
+	return io.NopCloser(io.NewSectionReader(s.R, offset, s.Size-offset)), s.Size - offset, nil
+}
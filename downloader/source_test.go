@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in                string
+		start, end, total int64
+		ok                bool
+	}{
+		{"bytes 0-99/100", 0, 99, 100, true},
+		{"bytes 20000-65535/65536", 20000, 65535, 65536, true},
+		{"bytes 5-9/*", 5, 9, -1, true},
+		{" bytes 5-9/10 ", 5, 9, 10, true},
+		{"bytes 5-9/9", 0, 0, 0, false}, // total must be past end
+		{"bytes 9-5/10", 0, 0, 0, false},
+		{"bytes -5/10", 0, 0, 0, false},
+		{"bytes 5-9", 0, 0, 0, false},
+		{"items 5-9/10", 0, 0, 0, false},
+		{"bytes */10", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, total, ok := parseContentRange(tt.in, "bytes")
+		if ok != tt.ok || start != tt.start || end != tt.end || total != tt.total {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, %v, want %d, %d, %d, %v",
+				tt.in, start, end, total, ok, tt.start, tt.end, tt.total, tt.ok)
+		}
+	}
+}
+
+// stub answering every request with 206, Content-Range and data from
+// offset, Content-Length is left out so total comes only from Content-Range
+func newContentRangeServer(t *testing.T, data []byte, offset int, contentRange string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentRange != "" {
+			w.Header().Set("Content-Range", contentRange)
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		w.Write(data[offset:])
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestResumeWithCorrectContentRange(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newContentRangeServer(t, data, 20000, fmt.Sprintf("bytes 20000-%d/%d", len(data)-1, len(data)))
+
+	d := newTestDownloader(t, srv.URL)
+	writePartial(t, d, data, 20000)
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if d.TotalSize != int64(len(data)) {
+		t.Fatalf("TotalSize is %d, want %d from Content-Range", d.TotalSize, len(data))
+	}
+}
+
+func TestResumeWithWrongContentRange(t *testing.T) {
+	data := testData(64 << 10)
+	tests := []struct {
+		name         string
+		offset       int
+		contentRange string
+		wantErr      string
+	}{
+		{"earlier start", 10000, fmt.Sprintf("bytes 10000-%d/%d", len(data)-1, len(data)), "offset 10000 instead of requested 20000"},
+		{"later start", 30000, fmt.Sprintf("bytes 30000-%d/%d", len(data)-1, len(data)), "offset 30000 instead of requested 20000"},
+		{"missing", 20000, "", "invalid Content-Range"},
+		{"garbage", 20000, "bytes twenty-thousand", "invalid Content-Range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newContentRangeServer(t, data, tt.offset, tt.contentRange)
+			d := newTestDownloader(t, srv.URL)
+			writePartial(t, d, data, 20000)
+
+			err := d.Download()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want error containing %q", err, tt.wantErr)
+			}
+			// misaligned data were not written
+			checkFile(t, d.FilePath+partSuffix, data[:20000])
+			if _, err := os.Stat(d.FilePath); !os.IsNotExist(err) {
+				t.Fatalf("output file exists: %v", err)
+			}
+		})
+	}
+}
+
+// stub of server that answers only requests with Range in given unit, 206
+// is the only success it knows, request without Range gets 400
+func newRangeOnlyServer(t *testing.T, data []byte, unit string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec, ok := strings.CutPrefix(r.Header.Get("Range"), unit+"=")
+		first, last, _ := strings.Cut(spec, "-")
+		start, err := strconv.Atoi(first)
+		end := len(data) - 1
+		if last != "" {
+			end, _ = strconv.Atoi(last)
+			end = min(end, len(data)-1)
+		}
+		if !ok || err != nil || start >= len(data) || end < start {
+			http.Error(w, "range required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("%s %d-%d/%d", unit, start, end, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method != http.MethodHead {
+			w.Write(data[start : end+1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestForceRange(t *testing.T) {
+	data := testData(256 << 10)
+
+	// without ForceRange fresh download is refused
+	d := newTestDownloader(t, newRangeOnlyServer(t, data, "bytes").URL)
+	err := d.Download()
+	var status *BadStatusError
+	if !errors.As(err, &status) || status.Code != http.StatusBadRequest {
+		t.Fatalf("got %v, want 400 without Range", err)
+	}
+
+	tests := []struct {
+		name        string
+		unit        string
+		partial     int
+		connections int
+	}{
+		{"fresh", "", 0, 1},
+		{"resume", "", 20000, 1},
+		{"parallel", "", 0, 4},
+		{"custom unit", "items", 0, 1},
+		{"custom unit resume", "items", 20000, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit := cmp.Or(tt.unit, "bytes")
+			d := newTestDownloader(t, newRangeOnlyServer(t, data, unit).URL)
+			d.ForceRange = true
+			d.RangeUnit = tt.unit
+			if tt.partial > 0 {
+				writePartial(t, d, data, tt.partial)
+			}
+			var err error
+			if tt.connections > 1 {
+				err = d.DownloadParallel(tt.connections)
+			} else {
+				err = d.Download()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFile(t, d.FilePath, data)
+			if d.TotalSize != int64(len(data)) {
+				t.Errorf("TotalSize is %d, want %d from Content-Range", d.TotalSize, len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import "path/filepath"
+
+// fail before downloading when filesystem of output file can't hold rest of
+// the file, space already taken by output file (e.g. preallocated) counts
+func (d *Downloader) checkFreeSpace() error {
+	if d.TotalSize <= 0 || d.isDevice {
+		return nil
+	}
+	info, err := d.OutputFile.Stat()
+	if err != nil {
+		return err
+	}
+	return d.requireSpace(d.TotalSize - max(info.Size(), d.Downloaded))
+}
+
+// fail when filesystem of output file has less than needed bytes free
+func (d *Downloader) requireSpace(needed int64) error {
+	if needed <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(d.dataPath())
+	available, err := freeSpace(dir)
+	if err != nil || available < 0 {
+		return nil // unknown free space doesn't stop download
+	}
+	if available < needed {
+		return &InsufficientSpaceError{Dir: dir, Needed: needed, Available: available}
+	}
+	return nil
+}
+
+// reserve TotalSize bytes for output file so download can't run out of
+// space later, filesystems without allocation support just get file size set
+func (d *Downloader) preallocate() error {
+	if d.TotalSize <= 0 || d.isDevice {
+		return nil
+	}
+	if err := allocate(d.OutputFile, d.TotalSize); err == nil {
+		return nil
+	}
+	return d.OutputFile.Truncate(d.TotalSize)
+}
+
+// drop preallocated space past downloaded data when server sent less than
+// announced, otherwise file would end with zeros
+func (d *Downloader) trimPreallocated() error {
+	if !d.Preallocate || d.isDevice || d.streaming() {
+		return nil
+	}
+	info, err := d.OutputFile.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > d.Downloaded {
+		return d.OutputFile.Truncate(d.Downloaded)
+	}
+	return nil
+}
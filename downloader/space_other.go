@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package downloader
+
+// free space is unknown here, check is skipped
+func freeSpace(dir string) (int64, error) {
+	return -1, nil
+}
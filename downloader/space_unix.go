@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package downloader
+
+import "golang.org/x/sys/unix"
+
+// bytes available to unprivileged user on filesystem containing dir
+func freeSpace(dir string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return -1, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,125 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// decide where download starts from files left by earlier runs, the result
+// is resume offset or error refusing download:
+//
+//	partial data + progress file, UseProgressFile     resume from recorded position
+//	partial data + progress file, no UseProgressFile  start from zero
+//	partial data without progress file                start from zero
+//	complete file, Overwrite                          start from zero
+//	complete file, no Overwrite                       ErrFileExists
+//	complete file, Timestamping or SkipIfUnchanged    start from zero, server decides
+//	expired signed URL with progress                  ErrURLExpired
+//	nothing                                           start from zero
+//
+// old data are never reused when starting from zero, openOutput truncates
+// them, and there is no skip state here, download is skipped only when
+// server answers conditional request with 304 (see Skipped), retried
+// attempt of the same Download call without progress file and stream to
+// stdout or writer continue from position kept in memory, block device
+// resumes from progress file only
+func (d *Downloader) resolveStartState() (startOffset int64, err error) {
+	if d.streaming() || (!d.UseProgressFile && d.Downloaded > 0) {
+		return d.Downloaded, nil
+	}
+
+	if d.UseProgressFile {
+		d.ReadProgress()
+		if d.Downloaded > 0 {
+			if err := d.checkResumeAllowed(); err != nil {
+				return 0, err
+			}
+			return d.Downloaded, nil
+		}
+	}
+	atomic.StoreInt64(&d.Downloaded, 0)
+	d.ResumedAt = 0
+	if d.isDevice {
+		return 0, nil
+	}
+
+	if err := d.checkOverwrite(); err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(d.dataPath()); err == nil {
+		d.debugf("nothing to resume, downloading %s from start", d.FilePath)
+	}
+	return 0, nil
+}
+
+// refuse resume when Url looks signed and its signature probably expired
+// since progress file was last written, expired URL would otherwise fail
+// or serve error page in the middle of file
+func (d *Downloader) checkResumeAllowed() error {
+	if d.RefreshURL != nil || d.RequestFunc != nil {
+		return nil
+	}
+	info, err := os.Stat(d.ProgressPath)
+	if err != nil {
+		return nil
+	}
+	age := time.Since(info.ModTime())
+	allow := d.AllowResume
+	if allow == nil {
+		allow = ShouldAllowResume
+	}
+	if allow(d.Url, age) {
+		return nil
+	}
+	return fmt.Errorf("%w: progress is %s old, resume from %d bytes needs fresh URL, remove %s to download again",
+		ErrURLExpired, age.Round(time.Second), d.Downloaded, d.ProgressPath)
+}
+
+// query parameters that mark URL as signed (S3, GCS, CloudFront, Azure SAS
+// or token of CDN), keys are compared case-insensitively
+var signatureParams = []string{
+	"x-amz-signature", "x-amz-expires", "x-goog-signature", "x-goog-expires",
+	"signature", "sig", "token", "expires", "se",
+}
+
+// presumed lifetime of signed URL that doesn't tell when it expires
+const signedURLLifetime = time.Hour
+
+// heuristic used when AllowResume is not set, URL without signature is
+// always resumed, signed one only until its expiry (X-Amz-Date with
+// X-Amz-Expires, or Unix time in Expires) or, when expiry is not readable,
+// while progress is younger than one hour, fresh URL without readable
+// expiry can't be told from old one so AllowResume has to accept it
+func ShouldAllowResume(rawURL string, progressAge time.Duration) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	query := make(map[string]string)
+	for key, values := range u.Query() {
+		query[strings.ToLower(key)] = values[0]
+	}
+	signed := slices.ContainsFunc(signatureParams, func(p string) bool {
+		_, ok := query[p]
+		return ok
+	})
+	if !signed {
+		return true
+	}
+
+	if date, err := time.Parse("20060102T150405Z", query["x-amz-date"]); err == nil {
+		if secs, err := strconv.ParseInt(query["x-amz-expires"], 10, 64); err == nil {
+			return time.Now().Before(date.Add(time.Duration(secs) * time.Second))
+		}
+	}
+	if unix, err := strconv.ParseInt(query["expires"], 10, 64); err == nil {
+		return time.Now().Before(time.Unix(unix, 0))
+	}
+	return progressAge < signedURLLifetime
+}
@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResolveStartState(t *testing.T) {
+	data := testData(4096)
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, d *Downloader)
+		wantOffset int64
+		wantErr    error
+	}{
+		{"nothing", nil, 0, nil},
+		{"partial with progress", func(t *testing.T, d *Downloader) {
+			writePartial(t, d, data, 1000)
+		}, 1000, nil},
+		{"partial with progress, no UseProgressFile", func(t *testing.T, d *Downloader) {
+			writePartial(t, d, data, 1000)
+			d.UseProgressFile = false
+		}, 0, nil},
+		{"partial without progress", func(t *testing.T, d *Downloader) {
+			writePartial(t, d, data, 1000)
+			os.Remove(d.ProgressPath)
+		}, 0, nil},
+		{"complete file, Overwrite", func(t *testing.T, d *Downloader) {
+			os.WriteFile(d.FilePath, data, 0644)
+			d.Overwrite = true
+		}, 0, nil},
+		{"complete file", func(t *testing.T, d *Downloader) {
+			os.WriteFile(d.FilePath, data, 0644)
+		}, 0, ErrFileExists},
+		{"complete file, Timestamping", func(t *testing.T, d *Downloader) {
+			os.WriteFile(d.FilePath, data, 0644)
+			d.Timestamping = true
+		}, 0, nil},
+		{"complete file, SkipIfUnchanged", func(t *testing.T, d *Downloader) {
+			os.WriteFile(d.FilePath, data, 0644)
+			d.SkipIfUnchanged = true
+		}, 0, nil},
+		{"partial with progress, expired URL", func(t *testing.T, d *Downloader) {
+			writePartial(t, d, data, 1000)
+			d.AllowResume = func(string, time.Duration) bool { return false }
+		}, 0, ErrURLExpired},
+		{"retried attempt without progress file", func(t *testing.T, d *Downloader) {
+			writePartial(t, d, data, 1000)
+			os.Remove(d.ProgressPath)
+			d.UseProgressFile = false
+			d.Downloaded = 1500
+		}, 1500, nil},
+		{"stream to writer", func(t *testing.T, d *Downloader) {
+			os.WriteFile(d.FilePath, data, 0644)
+			d.sink = io.Discard
+			d.Downloaded = 700
+		}, 700, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDownloader(t, "http://example.com/file.bin")
+			if err := d.preparePaths(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, d)
+			}
+			offset, err := d.resolveStartState()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if offset != tt.wantOffset {
+				t.Fatalf("got offset %d, want %d", offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestDownloadRefusesCompleteFile(t *testing.T) {
+	data := testData(4096)
+	srv, ranges := newRangeRecorder(t, data)
+
+	d := newTestDownloader(t, srv.URL)
+	old := []byte("older version")
+	os.WriteFile(d.FilePath, old, 0644)
+	if err := d.Download(); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("got %v, want ErrFileExists", err)
+	}
+	checkFile(t, d.FilePath, old)
+	if got := ranges(); len(got) != 0 {
+		t.Fatalf("server got %d requests, want none", len(got))
+	}
+
+	d.Overwrite = true
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+}
+
+// starting from zero truncates data of earlier run, longer stale part
+// file would otherwise leave its tail behind downloaded file
+func TestDownloadFromZeroTruncatesStaleData(t *testing.T) {
+	data := testData(4096)
+	srv, ranges := newRangeRecorder(t, data)
+
+	d := newTestDownloader(t, srv.URL)
+	writePartial(t, d, testData(8192), 8192)
+	os.Remove(d.FilePath + partSuffix + ".progress")
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if got := ranges(); len(got) != 1 || got[0] != "" {
+		t.Fatalf("server got Range %q, want single request without Range", got)
+	}
+}
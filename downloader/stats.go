@@ -0,0 +1,46 @@
+package downloader
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes last Download call, counters start from zero with every call
+type Stats struct {
+	TotalBytes   int64         // bytes of file, resumed part included
+	SessionBytes int64         // bytes received by this call
+	Elapsed      time.Duration // wall clock time of call, retries included
+	AverageSpeed float64       // SessionBytes per second of Elapsed
+	PeakSpeed    float64       // highest speed between two progress updates, byte/s
+	Retries      int           // attempts repeated after failure
+	Redirects    int           // redirects followed by all attempts
+}
+
+// statistics of last download, during download they describe it so far
+func (d *Downloader) Stats() Stats {
+	s := Stats{
+		TotalBytes:   atomic.LoadInt64(&d.Downloaded),
+		SessionBytes: atomic.LoadInt64(&d.received),
+		PeakSpeed:    d.speeds.max(),
+		Retries:      int(atomic.LoadInt64(&d.retries)),
+		Redirects:    int(atomic.LoadInt64(&d.redirects)),
+	}
+	d.sessionMu.Lock()
+	startedAt, endedAt := d.startedAt, d.endedAt
+	d.sessionMu.Unlock()
+	if startedAt.IsZero() {
+		return s
+	}
+	if endedAt.IsZero() {
+		s.Elapsed = time.Since(startedAt)
+	} else {
+		s.Elapsed = endedAt.Sub(startedAt)
+	}
+	if s.Elapsed > 0 {
+		s.AverageSpeed = float64(s.SessionBytes) / s.Elapsed.Seconds()
+	}
+
+	// download shorter than progress interval has no speed sample
+	s.PeakSpeed = max(s.PeakSpeed, s.AverageSpeed)
+	return s
+}
@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// download as stream read by caller instead of file, e.g. to feed parser
+// while data arrive, retries continue the stream from reached position and
+// speed limit, checksum and progress work as in DownloadTo, error of
+// download that fails before sending any data is returned right away, later
+// ones by Read, closing stream before its end cancels download
+func (d *Downloader) OpenStream() (io.ReadCloser, error) {
+	return d.OpenStreamContext(context.Background())
+}
+
+// same as OpenStream, cancelling ctx stops download
+func (d *Downloader) OpenStreamContext(ctx context.Context) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	w := &startWriter{w: pw, started: make(chan struct{})}
+	done := make(chan error, 1)
+	go func() {
+		err := d.DownloadToContext(ctx, w)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	select {
+	case <-w.started:
+	case err := <-done:
+		done <- err
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return &stream{PipeReader: pr, cancel: cancel, done: done}, nil
+}
+
+// writer signalling first write, stream is handed out once data flow
+type startWriter struct {
+	w       io.Writer
+	once    sync.Once
+	started chan struct{}
+}
+
+func (w *startWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	return w.w.Write(p)
+}
+
+// read end of download, Close stops download and waits until it ends
+type stream struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func (s *stream) Close() error {
+	s.cancel()
+	s.PipeReader.Close()
+	err := <-s.done
+	s.done <- err
+	return nil
+}
@@ -0,0 +1,200 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cancels request when no data arrive for Timeout, unlike http.Client.Timeout
+// it doesn't limit length of whole download, only of stalls, StallTimeout
+// is watched only once response body is being read: body is split into
+// consecutive windows of StallTimeout and window bringing less than
+// StallSpeed on average cancels request, so trickle of few bytes that keeps
+// Timeout from firing is caught too
+type idleWatchdog struct {
+	timeout      time.Duration
+	timer        *time.Timer
+	stallTimeout time.Duration
+	stallBytes   float64     // least bytes every stall window must bring
+	stallTimer   *time.Timer // created by reader
+	received     int64       // bytes of current stall window, atomic
+	onStall      func(received int64)
+	cancel       context.CancelCauseFunc
+
+	mu     sync.Mutex // orders stall check with pause
+	paused bool
+}
+
+const defaultStallSpeed = 1024 // byte/s
+
+// derive context cancelled after Timeout without data or StallTimeout with
+// too little of them, watchdog is nil when neither is set, nil watchdog is
+// safe to use
+func (d *Downloader) watchIdle(ctx context.Context) (context.Context, *idleWatchdog) {
+	if d.Timeout <= 0 && d.StallTimeout <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	speed := d.stallSpeed()
+	w := &idleWatchdog{
+		timeout:      d.Timeout,
+		stallTimeout: d.StallTimeout,
+		stallBytes:   float64(speed) * d.StallTimeout.Seconds(),
+		cancel:       cancel,
+	}
+	if d.Timeout > 0 {
+		w.timer = time.AfterFunc(d.Timeout, func() {
+			cancel(&IdleTimeoutError{Idle: d.Timeout})
+		})
+	}
+	w.onStall = func(received int64) {
+		d.logger().Warn("download stalled", "url", d.Url, "downloaded", atomic.LoadInt64(&d.Downloaded),
+			"stall_timeout", d.StallTimeout, "stall_speed", speed, "received", received)
+		cancel(&StallError{Stalled: d.StallTimeout, Received: received, MinSpeed: speed})
+	}
+	return ctx, w
+}
+
+// least average speed of stall window, default when StallSpeed isn't set
+func (d *Downloader) stallSpeed() int64 {
+	if d.StallSpeed > 0 {
+		return d.StallSpeed
+	}
+	return defaultStallSpeed
+}
+
+// wrap reader so every successful read restarts idle timer and counts
+// toward current stall window
+func (w *idleWatchdog) reader(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	if w.stallTimeout > 0 {
+		w.mu.Lock()
+		w.stallTimer = time.AfterFunc(w.stallTimeout, w.checkStall)
+		w.mu.Unlock()
+	}
+	return &idleReader{r: r, w: w}
+}
+
+// end of stall window, too little data cancels request, otherwise next
+// window starts from zero
+func (w *idleWatchdog) checkStall() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused {
+		return
+	}
+	received := atomic.SwapInt64(&w.received, 0)
+	if float64(received) < w.stallBytes {
+		w.onStall(received)
+		return
+	}
+	w.stallTimer.Reset(w.stallTimeout)
+}
+
+// stop timers while download is paused
+func (w *idleWatchdog) suspend() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+	for _, t := range []*time.Timer{w.timer, w.stallTimer} {
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
+
+// run timers again after pause, stall window starts anew so time before
+// pause doesn't count
+func (w *idleWatchdog) restart() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = false
+	if w.timer != nil {
+		w.timer.Reset(w.timeout)
+	}
+	if w.stallTimer != nil {
+		atomic.StoreInt64(&w.received, 0)
+		w.stallTimer.Reset(w.stallTimeout)
+	}
+}
+
+// note n bytes read
+func (w *idleWatchdog) read(n int) {
+	if w.timer != nil {
+		w.timer.Reset(w.timeout)
+	}
+	atomic.AddInt64(&w.received, int64(n))
+}
+
+// release timers and context
+func (w *idleWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	w.suspend()
+	w.cancel(nil)
+}
+
+// replace cancellation caused by watchdog with IdleTimeoutError or StallError
+func (w *idleWatchdog) err(ctx context.Context, err error) error {
+	if w == nil || err == nil {
+		return err
+	}
+	var idleErr *IdleTimeoutError
+	var stallErr *StallError
+	cause := context.Cause(ctx)
+	if errors.As(cause, &idleErr) {
+		return idleErr
+	}
+	if errors.As(cause, &stallErr) {
+		return stallErr
+	}
+	return err
+}
+
+// derive context cancelled when whole download, retries included, runs
+// for MaxDuration, works together with idle watchdog of every attempt
+func (d *Downloader) limitDuration(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.MaxDuration <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, d.MaxDuration, ErrMaxDurationExceeded)
+}
+
+// replace failure caused by MaxDuration deadline with ErrMaxDurationExceeded,
+// failed progress write is kept because it means resume won't work
+func durationErr(ctx context.Context, err error) error {
+	if err != nil && context.Cause(ctx) == ErrMaxDurationExceeded {
+		var progressErr *ProgressWriteError
+		if errors.As(err, &progressErr) {
+			return errors.Join(ErrMaxDurationExceeded, progressErr)
+		}
+		return ErrMaxDurationExceeded
+	}
+	return err
+}
+
+type idleReader struct {
+	r io.Reader
+	w *idleWatchdog
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.w.read(n)
+	}
+	return n, err
+}
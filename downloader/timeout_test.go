@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// server sending chunk bytes of data every interval, client gets slow but
+// steadily progressing download
+func newTrickleServer(t *testing.T, data []byte, chunk int, interval time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		for off := 0; off < len(data); off += chunk {
+			if _, err := w.Write(data[off:min(off+chunk, len(data))]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMaxDurationStopsProgressingDownload(t *testing.T) {
+	data := testData(1 << 20)
+	srv := newTrickleServer(t, data, 1024, 10*time.Millisecond)
+
+	d := newTestDownloader(t, srv.URL)
+	d.Timeout = 200 * time.Millisecond // data keep arriving, idle timeout never fires
+	d.MaxDuration = 300 * time.Millisecond
+	start := time.Now()
+	err := d.Download()
+	elapsed := time.Since(start)
+	if !errors.Is(err, ErrMaxDurationExceeded) {
+		t.Fatalf("got %v, want ErrMaxDurationExceeded", err)
+	}
+	if elapsed < d.MaxDuration || elapsed > d.MaxDuration+500*time.Millisecond {
+		t.Fatalf("download stopped after %s, MaxDuration is %s", elapsed.Round(time.Millisecond), d.MaxDuration)
+	}
+
+	// progress is kept so download can be resumed later
+	state, err := LoadProgress(d.ProgressPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Downloaded <= 0 || state.Downloaded >= int64(len(data)) {
+		t.Fatalf("progress file records %d bytes", state.Downloaded)
+	}
+}
+
+func TestStallSpeed(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunk        int
+		interval     time.Duration
+		stallSpeed   int64
+		wantMinSpeed int64
+		wantStalled  bool
+	}{
+		{"trickle below limit", 100, 10 * time.Millisecond, 50_000, 50_000, true},        // ~10 KB/s
+		{"trickle below default", 10, 20 * time.Millisecond, 0, defaultStallSpeed, true}, // ~500 B/s
+		{"steady above limit", 4096, 10 * time.Millisecond, 10_000, 0, false},            // ~400 KB/s
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testData(64 << 10)
+			srv := newTrickleServer(t, data, tt.chunk, tt.interval)
+
+			d := newTestDownloader(t, srv.URL)
+			d.MaxRetries = 0
+			d.Timeout = time.Second // data keep arriving, idle timeout never fires
+			d.StallTimeout = 100 * time.Millisecond
+			d.StallSpeed = tt.stallSpeed
+			start := time.Now()
+			err := d.Download()
+			elapsed := time.Since(start)
+			if !tt.wantStalled {
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkFile(t, d.FilePath, data)
+				return
+			}
+
+			var stall *StallError
+			if !errors.As(err, &stall) || !errors.Is(err, ErrStalled) {
+				t.Fatalf("got %v, want StallError", err)
+			}
+			if stall.MinSpeed != tt.wantMinSpeed || stall.Stalled != d.StallTimeout {
+				t.Errorf("stalled below %d B/s in %s, want %d B/s in %s", stall.MinSpeed, stall.Stalled, tt.wantMinSpeed, d.StallTimeout)
+			}
+			if stall.Received <= 0 || float64(stall.Received) >= float64(stall.MinSpeed)*d.StallTimeout.Seconds() {
+				t.Errorf("window received %d bytes", stall.Received)
+			}
+			// first window already decides, Timeout would take much longer
+			if elapsed > 3*d.StallTimeout+200*time.Millisecond {
+				t.Errorf("stall detected after %s", elapsed.Round(time.Millisecond))
+			}
+		})
+	}
+}
+
+func TestStallSpeedIgnoresPause(t *testing.T) {
+	data := testData(64 << 10)
+	srv := newTrickleServer(t, data, 4096, 10*time.Millisecond)
+
+	d := newTestDownloader(t, srv.URL)
+	d.MaxRetries = 0
+	d.StallTimeout = 50 * time.Millisecond
+	d.StallSpeed = 10_000
+	paused := false
+	d.ProgressInterval = 5 * time.Millisecond
+	d.OnProgress = func(downloaded, total int64, bps float64, eta int64) {
+		if !paused && downloaded > 0 {
+			paused = true
+			d.Pause()
+			time.AfterFunc(300*time.Millisecond, d.Resume)
+		}
+	}
+	if err := d.Download(); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, d.FilePath, data)
+	if !paused {
+		t.Fatal("download was never paused")
+	}
+}
@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// local file is downloaded only when remote one changed
+func (d *Downloader) conditional() bool {
+	return d.Timestamping || d.SkipIfUnchanged
+}
+
+// info about already present complete local file used by conditional download
+func (d *Downloader) localFile() (os.FileInfo, bool) {
+	if d.Downloaded > 0 || d.isDevice {
+		return nil, false
+	}
+	info, err := os.Stat(d.FilePath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	return info, true
+}
+
+// ask server to send file only when it changed after local copy was written
+func (d *Downloader) setIfModifiedSince(req *http.Request) {
+	if info, ok := d.localFile(); ok {
+		req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	}
+}
+
+// local file is up to date when server answers 304, Timestamping like
+// wget -N also accepts server that ignored If-Modified-Since when remote
+// file is not newer and has the same size as local one
+func (d *Downloader) upToDate(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusNotModified {
+		return true
+	}
+	info, ok := d.localFile()
+	if !ok || !d.Timestamping || resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	remoteTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil || remoteTime.After(info.ModTime()) {
+		return false
+	}
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return err == nil && size == info.Size()
+}
+
+// set mtime of downloaded file to server's Last-Modified so next
+// conditional run compares against remote time, not time of download
+func (d *Downloader) stampFile() error {
+	if d.LastModified.IsZero() {
+		return nil
+	}
+	return os.Chtimes(d.FilePath, time.Now(), d.LastModified)
+}
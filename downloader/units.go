@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parse size like "500K", "1.5GB" or "64KiB" into bytes, prefixes are
+// decimal (K = 1000) unless followed by i (Ki = 1024), plain number is bytes
+func ParseSize(s string) (int64, error) {
+	return parseUnits(s, false)
+}
+
+// parse speed like "500K", "2MB/s" or "1.5Mbps" into byte/s, /s or ps
+// suffix is optional, lowercase b and bit mean bits, B or no unit bytes
+func ParseSpeed(s string) (int64, error) {
+	return parseUnits(s, true)
+}
+
+func parseUnits(s string, rate bool) (int64, error) {
+	kind := "size"
+	if rate {
+		kind = "speed"
+	}
+	value := strings.TrimSpace(s)
+	if rate {
+		if strings.HasSuffix(strings.ToLower(value), "/s") {
+			value = value[:len(value)-2]
+		} else if strings.HasSuffix(value, "ps") {
+			value = value[:len(value)-2]
+		}
+	}
+
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end < 0 {
+		end = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid %s %q", kind, s)
+	}
+	unit := strings.TrimSpace(value[end:])
+
+	bits := false
+	switch {
+	case strings.HasSuffix(unit, "bits"):
+		unit, bits = strings.TrimSuffix(unit, "bits"), true
+	case strings.HasSuffix(unit, "bit"):
+		unit, bits = strings.TrimSuffix(unit, "bit"), true
+	case strings.HasSuffix(unit, "b"):
+		unit, bits = strings.TrimSuffix(unit, "b"), true
+	case strings.HasSuffix(unit, "B"):
+		unit = strings.TrimSuffix(unit, "B")
+	}
+	if bits && !rate {
+		return 0, fmt.Errorf("invalid %s %q: sizes are in bytes", kind, s)
+	}
+
+	base := 1000.0
+	if strings.HasSuffix(unit, "i") && len(unit) == 2 {
+		unit, base = unit[:1], 1024.0
+	}
+	exponents := map[string]float64{"": 0, "k": 1, "m": 2, "g": 3, "t": 4}
+	exp, ok := exponents[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %q: unknown unit", kind, s)
+	}
+
+	bytes := number * math.Pow(base, exp)
+	if bits {
+		bytes /= 8
+	}
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid %s %q: too large", kind, s)
+	}
+	return int64(bytes), nil
+}
@@ -0,0 +1,73 @@
+package downloader
+
+import "testing"
+
+func TestParseSpeed(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100B", 100},
+		{"500K", 500_000},
+		{"500k", 500_000},
+		{"500KB", 500_000},
+		{"2MB/s", 2_000_000},
+		{"2MB/S", 2_000_000},
+		{"2MBps", 2_000_000},
+		{"1.5 MB", 1_500_000},
+		{" 1G ", 1_000_000_000},
+		{"1T", 1_000_000_000_000},
+		{"64KiB/s", 65536},
+		{"1MiB", 1 << 20},
+		{"1.5Mbps", 187_500},
+		{"10Mbit/s", 1_250_000},
+		{"10Mbits", 1_250_000},
+		{"8bps", 1},
+		{"1Kib", 128},
+	}
+	for _, tt := range tests {
+		got, err := ParseSpeed(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSpeed(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "-1", "5X", "1..5M", "1e3", "M", "99999999T", "2MB/s/s"} {
+		if got, err := ParseSpeed(in); err == nil {
+			t.Errorf("ParseSpeed(%q) = %d, want error", in, got)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100B", 100},
+		{"500K", 500_000},
+		{"1.5GB", 1_500_000_000},
+		{"1M", 1_000_000},
+		{"64KiB", 65536},
+		{"1Mi", 1 << 20},
+		{"2TiB", 2 << 40},
+		{"4 KB", 4000},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	// bits, rates and garbage are refused
+	for _, in := range []string{"", "x", "-5", "1Q", "10Mb", "4 kb", "10MB/s", "1e3", "99999999T"} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want error", in, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// VerifyFailurePolicy decides what happens with downloaded data that fail
+// verification
+type VerifyFailurePolicy int
+
+const (
+	// keep data for investigation, progress file is removed so next run
+	// downloads file again from start instead of resuming at its end
+	KeepOnVerifyFailure VerifyFailurePolicy = iota
+	// remove data and progress file
+	DeleteOnVerifyFailure
+	// remove data and progress file and download once more from scratch,
+	// useful when remote file changed and partial data are worthless, also
+	// restarts download whose resume found file of other size
+	RestartOnVerifyFailure
+	// restart like RestartOnVerifyFailure only when remote file was seen
+	// changing (other total size on resume or full content for If-Range),
+	// otherwise corruption is taken for transient and data are kept like
+	// with KeepOnVerifyFailure
+	RestartIfChangedOnVerifyFailure
+)
+
+// handle data failing verification or resume that found file of other size
+// according to OnVerifyFailure, retry downloads file again from scratch,
+// nil retry keeps data of download that was already restarted
+func (d *Downloader) handleVerifyFailure(err error, retry func() error) error {
+	var mismatch *ChecksumMismatchError
+	var sizeMismatch *SizeMismatchError
+	var sizeChanged *SizeChangedError
+	failed := errors.As(err, &mismatch) || errors.As(err, &sizeMismatch)
+	changed := errors.As(err, &sizeChanged)
+	if !failed && !changed {
+		return err
+	}
+
+	policy := d.OnVerifyFailure
+	if policy == RestartIfChangedOnVerifyFailure {
+		policy = KeepOnVerifyFailure
+		if changed || d.remoteChanged {
+			policy = RestartOnVerifyFailure
+		}
+	}
+	if policy == RestartOnVerifyFailure && retry == nil {
+		policy = KeepOnVerifyFailure
+	}
+	switch {
+	case policy == RestartOnVerifyFailure:
+		d.printf("\n%v, downloading file again\n", err)
+		d.discard()
+		return d.handleVerifyFailure(retry(), nil)
+	case changed:
+		// resume is refused, progress stays so that caller can decide
+		return err
+	case policy == DeleteOnVerifyFailure:
+		d.discard()
+	case d.ProgressPath != "":
+		os.Remove(d.ProgressPath)
+	}
+	return err
+}
+
+// remove downloaded data and progress so next download starts from zero,
+// block device is never removed
+func (d *Downloader) discard() {
+	if !d.isDevice && !d.streaming() {
+		os.Remove(d.dataPath())
+	}
+	if d.ProgressPath != "" {
+		os.Remove(d.ProgressPath)
+	}
+	atomic.StoreInt64(&d.Downloaded, 0)
+	d.ResumedAt = 0
+	d.knownTotal = 0
+}
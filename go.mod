@@ -1,3 +1,8 @@
 module github.com/matejeliash/medow
 
 go 1.24.4
+
+require (
+	golang.org/x/sys v0.31.0
+	golang.org/x/term v0.30.0
+)
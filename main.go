@@ -1,13 +1,476 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/matejeliash/medow/downloader"
 )
 
+// exit codes of medow, scripts may branch on them so values must not change
+const (
+	exitOK          = 0   // download completed
+	exitError       = 1   // any other failure
+	exitUsage       = 2   // invalid flags or options
+	exitVerify      = 3   // checksum or size of downloaded data doesn't match
+	exitNoResume    = 4   // server doesn't support partial downloads needed for resume
+	exitInterrupted = 130 // Ctrl-C or SIGTERM, progress is kept for resume
+)
+
 func main() {
+	url := flag.String("url", "", "URL to download")
+	output := flag.String("output", "", "output file, \"-\" writes to stdout, empty takes name from server")
+	noProgress := flag.Bool("no-progress", false, "don't keep progress file, so download can't be resumed")
+	limit := flag.String("limit", "", "maximum download speed of all connections together, e.g. 500K, 2MB/s or 10Mbps, empty is unlimited")
+	adaptiveLimit := flag.Bool("adaptive-limit", false, "with -limit, lower speed while other traffic competes for the link, e.g. for background downloads")
+	connections := flag.Int("connections", 1, "number of parallel connections")
+	parallelThreshold := flag.String("parallel-threshold", "", "with -connections, download files up to this size over one connection, e.g. 50MB")
+	checksum := flag.String("checksum", "", "expected checksum as <algorithm>:<hex>, e.g. sha256:abcd...")
+	checksumURL := flag.String("checksum-url", "", "URL of checksum file like SHA256SUMS listing checksum of downloaded file")
+	quiet := flag.Bool("quiet", false, "print nothing except errors")
+	jsonOutput := flag.Bool("json", false, "print progress as JSON lines to stdout instead of progress bar")
+	printPath := flag.Bool("print-path", false, "print only path of every downloaded file to stdout, messages and progress go to stderr")
+	insecure := flag.Bool("insecure", false, "don't verify server TLS certificate")
+	caCert := flag.String("ca-cert", "", "PEM file with additional trusted CA certificates")
+	netrc := flag.Bool("netrc", false, "take login and password for server from ~/.netrc")
+	force := flag.Bool("force", false, "overwrite existing complete file")
+	allowDevice := flag.Bool("allow-device", false, "allow output to be block device (its content is destroyed), character device like /dev/stdout or named pipe")
+	timeout := flag.Duration("timeout", 0, "fail when no data arrive for this long, e.g. 30s, 0 waits forever")
+	stallTimeout := flag.Duration("stall-timeout", 0, "retry when transfer gets slower than -stall-speed for this long, e.g. 20s, 0 disables")
+	stallSpeed := flag.String("stall-speed", "", "with -stall-timeout, least speed of transfer, e.g. 10K or 1MB/s, empty uses 1 KiB/s")
+	retries := flag.Int("retries", 3, "number of retries of failed download, 0 disables retrying")
+	retryStatus := flag.String("retry-status", "", "comma separated HTTP status codes retried besides 5xx and 429, e.g. 408,425")
+	maxSize := flag.String("max-size", "", "fail when file is bigger than this, e.g. 2GB, empty is unlimited")
+	bufferSize := flag.String("buffer-size", "", "read buffer size, e.g. 64KiB or 1M, empty uses 32 KiB")
+	workDir := flag.String("work-dir", "", "keep partial data and progress file in this directory, complete file is moved to output")
+	persistEvery := flag.String("persist-every", "", "also write progress file after this much data, e.g. 64MB, empty writes it with every progress update")
+	connectTimeout := flag.Duration("timeout-connect", 0, "fail when connection to server takes longer, e.g. 5s, 0 uses system default")
+	progressInterval := flag.Duration("progress-interval", 0, "how often progress is shown, e.g. 250ms, 0 uses 1s")
+	extract := flag.String("extract", "", "unpack downloaded tar or tar.gz archive into this directory instead of saving it, without resume")
+	byteRange := flag.String("range", "", "download only bytes start-end (inclusive) or start- into output, without resume")
+	compressed := flag.Bool("compressed", false, "ask server for gzip or deflate compressed transfer, download can't be resumed then")
+	dryRun := flag.Bool("dry-run", false, "print what would be downloaded (for -input-file plan of all files) and exit without writing anything")
+	overall := flag.Bool("overall", false, "with -input-file, show progress of all files together, sizes are queried first")
+	inputFile := flag.String("input-file", "", "file with URLs to download one by one, -output is then directory")
+	jobs := flag.Int("jobs", 1, "with -input-file, number of files downloaded at the same time")
+	probeMaxAge := flag.Duration("probe-max-age", 0, "with -input-file, reuse size of file queried this long ago, e.g. 5m, 0 queries every time")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -url URL [options]\n       %s -input-file FILE [options]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nexit codes: %d completed, %d error, %d usage error, %d checksum or size mismatch,\n"+
+			"            %d server doesn't support resume, %d interrupted\n",
+			exitOK, exitError, exitUsage, exitVerify, exitNoResume, exitInterrupted)
+		fmt.Fprintf(os.Stderr, "\nenvironment: MEDOW_TIMEOUT, MEDOW_MAX_RETRIES, MEDOW_BUFFER_SIZE, MEDOW_SPEED_LIMIT and MEDOW_ALLOW_DEVICE\n"+
+			"             set defaults of -timeout, -retries, -buffer-size, -limit and -allow-device\n")
+	}
+	flag.Parse()
+	if err := applyEnv(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	if *retries < 0 {
+		fmt.Fprintln(os.Stderr, "-retries can't be negative")
+		os.Exit(exitUsage)
+	}
+
+	// old positional form "medow URL [FILE]" keeps working
+	if *url == "" && flag.NArg() > 0 {
+		*url = flag.Arg(0)
+	}
+	if *output == "" && flag.NArg() > 1 {
+		*output = flag.Arg(1)
+	}
+	if *url == "" && *inputFile == "" {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var speedLimit, stallBytes, bufSize, persistBytes, rangeStart, rangeEnd int64
+	var err error
+	if *byteRange != "" {
+		rangeStart, rangeEnd, err = parseRange(*byteRange)
+		if err == nil && (*inputFile != "" || *connections > 1 || *checksum != "") {
+			err = errors.New("-range can't be combined with -input-file, -connections or -checksum")
+		}
+		if err == nil && *output == "" {
+			err = errors.New("-range needs -output")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *extract != "" && (*byteRange != "" || *inputFile != "" || *connections > 1 || *output != "") {
+		fmt.Fprintln(os.Stderr, "-extract can't be combined with -range, -input-file, -connections or -output")
+		os.Exit(exitUsage)
+	}
+
+	if *printPath && (*jsonOutput || *extract != "" || *byteRange != "" || *dryRun || *output == "-") {
+		fmt.Fprintln(os.Stderr, "-print-path can't be combined with -json, -extract, -range, -dry-run or -output -")
+		os.Exit(exitUsage)
+	}
+
+	if *limit != "" {
+		if speedLimit, err = downloader.ParseSpeed(*limit); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+	if *stallSpeed != "" {
+		if stallBytes, err = downloader.ParseSpeed(*stallSpeed); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+	var retryCodes []int
+	if *retryStatus != "" {
+		if retryCodes, err = parseStatusCodes(*retryStatus); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+	if *bufferSize != "" {
+		if bufSize, err = downloader.ParseSize(*bufferSize); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	var maxBytes int64
+	if *maxSize != "" {
+		if maxBytes, err = downloader.ParseSize(*maxSize); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	var threshold int64
+	if *parallelThreshold != "" {
+		if threshold, err = downloader.ParseSize(*parallelThreshold); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if *persistEvery != "" {
+		if persistBytes, err = downloader.ParseSize(*persistEvery); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	// Config takes 0 as default number of retries
+	maxRetries := *retries
+	if maxRetries == 0 {
+		maxRetries = -1
+	}
+
+	cfg := downloader.Config{
+		URL:                *url,
+		FilePath:           *output,
+		UseProgressFile:    !*noProgress,
+		Overwrite:          *force,
+		AllowDevice:        *allowDevice,
+		Timeout:            *timeout,
+		StallTimeout:       *stallTimeout,
+		StallSpeed:         stallBytes,
+		MaxRetries:         maxRetries,
+		RetryStatusCodes:   retryCodes,
+		StagingDir:         *workDir,
+		PersistEveryBytes:  persistBytes,
+		ConnectTimeout:     *connectTimeout,
+		ProgressInterval:   *progressInterval,
+		RequestCompression: *compressed,
+		SpeedLimit:         speedLimit,
+		AdaptiveLimit:      *adaptiveLimit,
+		BufferSize:         bufSize,
+		MaxBytes:           maxBytes,
+		Checksum:           *checksum,
+		ChecksumURL:        *checksumURL,
+		Quiet:              *quiet,
+		InsecureSkipVerify: *insecure,
+		CACertFile:         *caCert,
+		UseNetrc:           *netrc,
+	}
+
+	// JSON lines replace all human readable output on stdout
+	if *jsonOutput {
+		if *output == "-" {
+			fmt.Fprintln(os.Stderr, "-json can't be used when data are written to stdout")
+			os.Exit(exitUsage)
+		}
+		cfg.Quiet = true
+		cfg.OnProgress = printJSONProgress
+	}
+
+	// path resolved from server response is known only once file is complete
+	if *printPath {
+		cfg.MessagesToStderr = true
+		cfg.OnComplete = func(path string, totalBytes int64, elapsed time.Duration) {
+			fmt.Println(path)
+		}
+	}
+
+	// Ctrl-C or SIGTERM cancels download, progress is flushed so next run
+	// resumes from exact position
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *inputFile != "" {
+		urls, err := readURLs(*inputFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		cfg.OutputDir = *output
+		q := &downloader.Queue{Config: cfg, Overall: *overall, Jobs: *jobs, Connections: *connections, ProbeMaxAge: *probeMaxAge}
+		if *dryRun {
+			printPlan(q.Plan(ctx, urls), *jsonOutput)
+		}
+		exit(q.Download(ctx, urls), *jsonOutput)
+	}
+
+	d, err := downloader.NewDownloaderWithConfig(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	if *parallelThreshold != "" {
+		d.AutoParallel = true
+		d.ParallelThreshold = threshold
+	}
+
+	if *dryRun {
+		report, err := d.DryRunParallelContext(ctx, *connections)
+		if err != nil {
+			exit(err, false)
+		}
+		fmt.Print(report)
+		return
+	}
+
+	if *extract != "" {
+		err = d.DownloadAndExtractContext(ctx, *extract)
+	} else if *byteRange != "" {
+		err = downloadRange(ctx, d, *output, rangeStart, rangeEnd, *force)
+	} else if *connections > 1 {
+		err = d.DownloadParallelContext(ctx, *connections)
+	} else {
+		err = d.DownloadContext(ctx)
+	}
+	exit(err, *jsonOutput)
+}
+
+var jsonOut = json.NewEncoder(os.Stdout)
+
+// one progress record of -json mode
+type jsonProgress struct {
+	Downloaded int64   `json:"downloaded"`
+	Total      int64   `json:"total"`
+	BPS        float64 `json:"bps"`
+	ETA        int64   `json:"eta"`
+	Percent    float64 `json:"percent"`
+}
+
+// final record of -json mode
+type jsonStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// write progress as JSON line, stdout is unbuffered so every line reaches
+// reader right away
+func printJSONProgress(downloaded, total int64, bps float64, eta int64) {
+	p := jsonProgress{Downloaded: downloaded, Total: total, BPS: bps, ETA: eta}
+	if total > 0 {
+		p.Percent = float64(downloaded) / float64(total) * 100
+	}
+	jsonOut.Encode(p)
+}
+
+// one planned download of -dry-run with -input-file in -json mode
+type jsonPlan struct {
+	Action string `json:"action"`
+	URL    string `json:"url"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	Resume int64  `json:"resume,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// print plan of queue as table or JSON lines and exit, plan with URL that
+// would fail exits with error
+func printPlan(entries []downloader.PlanEntry, jsonOutput bool) {
+	code := exitOK
+	for _, e := range entries {
+		switch e.Action {
+		case downloader.PlanError, downloader.PlanExists, downloader.PlanConflict:
+			code = exitError
+		}
+		if jsonOutput {
+			p := jsonPlan{Action: string(e.Action), URL: e.URL, Path: e.Path, Size: e.Size, Resume: e.Resume}
+			if e.Err != nil {
+				p.Error = e.Err.Error()
+			}
+			jsonOut.Encode(p)
+		}
+	}
+	if !jsonOutput {
+		fmt.Print(downloader.FormatPlan(entries))
+	}
+	os.Exit(code)
+}
+
+// environment variables setting defaults of flags, check validates value of
+// flag parsed only later
+var envFlags = []struct {
+	name, flag string
+	check      func(value string) error
+}{
+	{"MEDOW_TIMEOUT", "timeout", nil},
+	{"MEDOW_MAX_RETRIES", "retries", nil},
+	{"MEDOW_BUFFER_SIZE", "buffer-size", func(v string) error { _, err := downloader.ParseSize(v); return err }},
+	{"MEDOW_SPEED_LIMIT", "limit", func(v string) error { _, err := downloader.ParseSpeed(v); return err }},
+	{"MEDOW_ALLOW_DEVICE", "allow-device", nil},
+}
+
+// set flags not given on command line from environment, so flag wins over
+// environment and environment over built-in default
+func applyEnv() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, env := range envFlags {
+		value := os.Getenv(env.name)
+		if value == "" || set[env.flag] {
+			continue
+		}
+		err := flag.Set(env.flag, value)
+		if err == nil && env.check != nil {
+			err = env.check(value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s=%q: %w", env.name, value, err)
+		}
+	}
+	return nil
+}
+
+// exit with code matching result of download, jsonOutput adds final record
+func exit(err error, jsonOutput bool) {
+	if jsonOutput {
+		if err != nil {
+			jsonOut.Encode(jsonStatus{Status: "error", Error: err.Error()})
+		} else {
+			jsonOut.Encode(jsonStatus{Status: "complete"})
+		}
+	}
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "\nDownload interrupted, run again to resume")
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nDownload failed:", err)
+		os.Exit(exitCode(err))
+	}
+	os.Exit(exitOK)
+}
+
+// exit code for failed download
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, downloader.ErrChecksumMismatch), errors.Is(err, downloader.ErrSizeMismatch):
+		return exitVerify
+	case errors.Is(err, downloader.ErrRangeNotSupported):
+		return exitNoResume
+	default:
+		return exitError
+	}
+}
+
+// parse -range value "start-end" or open-ended "start-", end is then -1
+func parseRange(s string) (start, end int64, err error) {
+	first, last, ok := strings.Cut(s, "-")
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if last == "" {
+		end, err2 = -1, nil
+	}
+	if !ok || err1 != nil || err2 != nil || start < 0 || (end >= 0 && end < start) {
+		return 0, 0, fmt.Errorf("invalid range %q, use start-end or start-", s)
+	}
+	return start, end, nil
+}
+
+// parse -retry-status value like "408,425"
+func parseStatusCodes(s string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %q in -retry-status", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// download bytes of -range into output or stdout for "-", file of failed
+// download is removed because range download isn't resumed
+func downloadRange(ctx context.Context, d *downloader.Downloader, output string, start, end int64, force bool) error {
+	if output == "-" {
+		return d.DownloadRangeContext(ctx, os.Stdout, start, end)
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(output, flags, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s: %w, use -force to overwrite it", output, downloader.ErrFileExists)
+	}
+	if err != nil {
+		return err
+	}
+	err = d.DownloadRangeContext(ctx, f, start, end)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+	}
+	return err
+}
+
+// read newline separated URLs, empty lines and # comments are skipped
+func readURLs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	d := downloader.NewDownloader(os.Args[1], os.Args[2], true)
-	d.Download()
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
 }